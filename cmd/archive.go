@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/rs/zerolog/log"
+)
+
+// tarGzWriter writes a gzip compressed tar archive.
+type tarGzWriter struct {
+	file *os.File
+	gz   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func newTarGzWriter(path string) (*tarGzWriter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", path, err)
+	}
+
+	gz := gzip.NewWriter(file)
+	return &tarGzWriter{
+		file: file,
+		gz:   gz,
+		tw:   tar.NewWriter(gz),
+	}, nil
+}
+
+// AddFile adds a regular file with the given content to the archive.
+func (w *tarGzWriter) AddFile(name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    filepath.ToSlash(name),
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write header for %s: %w", name, err)
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddDir adds every regular file below srcDir to the archive under prefix.
+// If transform is not nil it is called with the path relative to srcDir and
+// the file content, and its result is archived instead of the original content.
+func (w *tarGzWriter) AddDir(srcDir, prefix string, transform func(rel string, data []byte) []byte) error {
+	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(srcDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(prefix, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel == "." {
+				return nil
+			}
+			hdr := &tar.Header{
+				Typeflag: tar.TypeDir,
+				Name:     filepath.ToSlash(name) + "/",
+				Mode:     int64(info.Mode().Perm()),
+				ModTime:  info.ModTime(),
+			}
+			return w.tw.WriteHeader(hdr)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if transform != nil {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return w.AddFile(name, transform(rel, data))
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(name)
+		if err := w.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = f.Close() }()
+
+		return w.copyEntry(path, f, hdr.Size)
+	})
+}
+
+// copyEntry writes size bytes of r as the content of the current entry.
+// Logs keep growing while they are archived, only the size of the header is
+// written; a file truncated or rotated in the meantime is padded with zeros
+// to keep the archive valid.
+func (w *tarGzWriter) copyEntry(path string, r io.Reader, size int64) error {
+	n, err := io.CopyN(w.tw, r, size)
+	if errors.Is(err, io.EOF) {
+		log.Warn().Str("file", path).Int64("size", size).Int64("archived", n).
+			Msg("File shrank while archiving, padding it with zeros")
+		_, err = io.CopyN(w.tw, zeroReader{}, size-n)
+	}
+	return err
+}
+
+// zeroReader reads an endless stream of zeros.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func (w *tarGzWriter) Close() error {
+	var err error
+	if inErr := w.tw.Close(); inErr != nil {
+		err = multierror.Append(err, inErr)
+	}
+	if inErr := w.gz.Close(); inErr != nil {
+		err = multierror.Append(err, inErr)
+	}
+	if inErr := w.file.Close(); inErr != nil {
+		err = multierror.Append(err, inErr)
+	}
+	return err
+}
+
+// extractTarGz extracts a gzip compressed tar archive into destDir.
+// Entries escaping destDir are rejected.
+func extractTarGz(path, destDir string) (err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer func() { _ = gz.Close() }()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		target := filepath.Join(destDir, filepath.FromSlash(hdr.Name))
+		if !isBelow(target, destDir) {
+			return fmt.Errorf("invalid entry %s in %s", hdr.Name, path)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("failed to create directory: %w", err)
+			}
+			if err := writeTarEntry(tr, target, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeTarEntry(r io.Reader, path string, mode fs.FileMode) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return out.Close()
+}
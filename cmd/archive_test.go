@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTarGzWriterRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"cloudserver/s3.log":  "listening on 8000\n",
+		"vault/vault.log":     "vault ready\n",
+		"backbeat/empty.log":  "",
+		"config/secrets.json": `{"password": "hunter2"}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	w, err := newTarGzWriter(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddDir(src, "logs", nil); err != nil {
+		t.Fatalf("AddDir failed: %v", err)
+	}
+	if err := w.AddFile("manifest.txt", []byte("workbench\n")); err != nil {
+		t.Fatalf("AddFile failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := t.TempDir()
+	if err := extractTarGz(archive, dest); err != nil {
+		t.Fatalf("extractTarGz failed: %v", err)
+	}
+	files["../manifest.txt"] = "workbench\n"
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(dest, "logs", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, data)
+		}
+	}
+}
+
+func TestTarGzWriterShrunkFile(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	w, err := newTarGzWriter(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file was 10 bytes long when stat'ed, only 3 are left to read
+	if err := w.tw.WriteHeader(&tar.Header{Name: "s3.log", Mode: 0644, Size: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.copyEntry("s3.log", strings.NewReader("abc"), 10); err != nil {
+		t.Fatalf("expected a short file to be padded, got %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("expected a valid archive, got %v", err)
+	}
+
+	dest := t.TempDir()
+	if err := extractTarGz(archive, dest); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dest, "s3.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := append([]byte("abc"), make([]byte, 7)...); !bytes.Equal(data, expected) {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestExtractTarGzRejectsEscapingEntries(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "evil.tar.gz")
+	w, err := newTarGzWriter(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.AddFile("../escaped.txt", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractTarGz(archive, t.TempDir()); err == nil {
+		t.Fatal("expected an entry escaping the destination to be rejected")
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// backbeatMaxCount bounds the instances of a backbeat program, each one
+// taking an inspector port.
+const backbeatMaxCount = 10
+
+type backbeatProgram struct {
+	Name string
+	// Script is the npm script starting the program
+	Script string
+	// Feature enables the program, any backbeat feature when empty
+	Feature string
+	// Env are the default environment variables of the program
+	Env map[string]string
+	// Optional programs only run when enabled in backbeat.processes
+	Optional bool
+	// Singleton programs can't run more than one instance, e.g. the
+	// populators tailing the oplog or the conductor scheduling lifecycle
+	Singleton bool
+}
+
+// backbeatPrograms lists the supervisord programs of backbeat with the
+// feature enabling them. The index of a program is its inspector port offset,
+// the optional programs come last and get theirs like extra instances.
+var backbeatPrograms = []backbeatProgram{
+	{Name: "lifecycle-conductor", Script: "lifecycle_conductor", Feature: "lifecycle", Singleton: true},
+	{Name: "lifecycle-bucket-processor", Script: "lifecycle_bucket_processor", Feature: "lifecycle"},
+	{Name: "lifecycle-object-processor", Script: "lifecycle_object_processor", Feature: "lifecycle"},
+	{Name: "notification-populator", Script: "notification_populator", Feature: "bucket_notifications", Singleton: true,
+		Env: map[string]string{"BACKBEAT_CONFIG_FILE": "/conf/config.notification.json"}},
+	{Name: "notification-processor", Script: "notification_processor", Feature: "bucket_notifications",
+		Env: map[string]string{"BACKBEAT_CONFIG_FILE": "/conf/config.notification.json"}},
+	{Name: "crr-queue-populator", Script: "queue_populator", Feature: "cross_region_replication", Singleton: true},
+	{Name: "crr-queue-processor", Script: "queue_processor", Feature: "cross_region_replication",
+		Env: map[string]string{"BOOTSTRAP_SITE_NAME": "sf"}},
+	{Name: "crr-status-processor", Script: "replication_status_processor", Feature: "cross_region_replication"},
+	{Name: "garbage-collector", Script: "garbage_collector", Feature: "lifecycle", Optional: true},
+	{Name: "ingestion-populator", Script: "ingestion_populator", Optional: true, Singleton: true},
+	{Name: "crr-failed-consumer", Script: "failed_crr_consumer", Feature: "cross_region_replication", Optional: true},
+}
+
+var (
+	backbeatProgramPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	backbeatScriptPattern  = regexp.MustCompile(`^[A-Za-z0-9_:.-]+$`)
+	backbeatEnvPattern     = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// BackbeatProcessConfig overrides the settings of a backbeat program. Names
+// other than the backbeat programs declare custom programs running Script.
+type BackbeatProcessConfig struct {
+	// Enabled defaults to the feature of the program, optional and custom
+	// programs are disabled by default
+	Enabled *bool             `yaml:"enabled"`
+	Count   *int              `yaml:"count"`
+	Env     map[string]string `yaml:"env"`
+	Script  string            `yaml:"script"`
+}
+
+// BackbeatProcess is a supervisord program rendered in supervisord.conf.
+type BackbeatProcess struct {
+	Name   string
+	Script string
+	// Args are appended to the npm script, e.g. the destination of a
+	// notification processor
+	Args string
+	// Env is the supervisord environment line, empty without variables
+	Env    string
+	Offset int
+}
+
+// backbeatDefaultPrograms is the number of programs running by default,
+// before the optional ones.
+func backbeatDefaultPrograms() int {
+	return slices.IndexFunc(backbeatPrograms, func(p backbeatProgram) bool { return p.Optional })
+}
+
+// resolveProcesses computes the programs of backbeat from the enabled
+// features and backbeat.processes. Each instance gets its own inspector
+// offset: the default programs keep their index, extra notification
+// processors follow (see notificationProcessorOffset), then the optional
+// programs and the extra instances.
+func (c *BackbeatConfig) resolveProcesses(features FeatureConfig) error {
+	programs := slices.Clone(backbeatPrograms)
+	for _, name := range slices.Sorted(maps.Keys(c.Processes)) {
+		if !backbeatProgramPattern.MatchString(name) {
+			return fmt.Errorf("invalid process name %q, use lowercase letters, digits and -", name)
+		}
+		if !slices.ContainsFunc(programs, func(p backbeatProgram) bool { return p.Name == name }) {
+			if c.Processes[name].Script == "" {
+				return fmt.Errorf("unknown process %s needs a script", name)
+			}
+			programs = append(programs, backbeatProgram{Name: name, Optional: true})
+		}
+	}
+
+	backbeatRunning := features.CrossRegionReplication.Enabled ||
+		features.BucketNotifications.Enabled ||
+		features.Lifecycle.Enabled
+	destinations := features.BucketNotifications.Destinations
+	nextOffset := backbeatDefaultPrograms() + max(len(destinations)-1, 0)
+
+	c.Programs = nil
+	for i, program := range programs {
+		settings := c.Processes[program.Name]
+		enabled := !program.Optional
+		if settings.Enabled != nil {
+			enabled = *settings.Enabled
+		}
+		count := 1
+		if settings.Count != nil {
+			count = *settings.Count
+		}
+		if count < 0 || count > backbeatMaxCount {
+			return fmt.Errorf("count of %s must be between 0 and %d", program.Name, backbeatMaxCount)
+		}
+		if program.Singleton && count > 1 {
+			return fmt.Errorf("%s runs a single instance, its count can only be 0 or 1", program.Name)
+		}
+		if !enabled || count == 0 {
+			continue
+		}
+
+		if program.Feature == "" {
+			if !backbeatRunning {
+				return fmt.Errorf("%s needs backbeat, enable lifecycle, bucket_notifications or cross_region_replication", program.Name)
+			}
+		} else if !features.isEnabled(program.Feature) {
+			if program.Optional && settings.Enabled != nil {
+				return fmt.Errorf("%s needs the %s feature", program.Name, program.Feature)
+			}
+			continue
+		}
+
+		script := program.Script
+		if settings.Script != "" {
+			script = settings.Script
+		}
+		if !backbeatScriptPattern.MatchString(script) {
+			return fmt.Errorf("invalid script %q of %s", script, program.Name)
+		}
+		env, err := backbeatEnv(program.Env, settings.Env)
+		if err != nil {
+			return fmt.Errorf("invalid env of %s: %w", program.Name, err)
+		}
+
+		// Notification processors run once per destination
+		base := []BackbeatProcess{{Name: program.Name, Script: script, Env: env, Offset: i}}
+		if program.Name == "notification-processor" {
+			base = base[:0]
+			for _, d := range destinations {
+				base = append(base, BackbeatProcess{
+					Name:   program.Name + "-" + d.Resource,
+					Script: script,
+					Args:   d.Resource,
+					Env:    env,
+					Offset: d.Offset,
+				})
+			}
+		} else if program.Optional {
+			base[0].Offset = nextOffset
+			nextOffset++
+		}
+
+		for _, p := range base {
+			c.Programs = append(c.Programs, p)
+			for n := 2; n <= count; n++ {
+				extra := p
+				extra.Name = fmt.Sprintf("%s-%d", p.Name, n)
+				extra.Offset = nextOffset
+				nextOffset++
+				c.Programs = append(c.Programs, extra)
+			}
+		}
+	}
+	return nil
+}
+
+// backbeatEnv merges the environment variables of a program into a
+// supervisord environment line, escaping % from its interpolation.
+func backbeatEnv(defaults, overrides map[string]string) (string, error) {
+	env := maps.Clone(defaults)
+	if env == nil {
+		env = map[string]string{}
+	}
+	maps.Copy(env, overrides)
+
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		value := env[key]
+		if !backbeatEnvPattern.MatchString(key) {
+			return "", fmt.Errorf("invalid variable name %q", key)
+		}
+		if strings.ContainsAny(value, "\"\n") {
+			return "", errors.New("values can't contain quotes or newlines")
+		}
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, key, strings.ReplaceAll(value, "%", "%%")))
+	}
+	return strings.Join(pairs, ","), nil
+}
+
+// ProgramEnabled reports whether an instance of the program runs, for the
+// extensions of config.json.
+func (c BackbeatConfig) ProgramEnabled(name string) bool {
+	return slices.ContainsFunc(c.Programs, func(p BackbeatProcess) bool {
+		return p.Name == name || strings.HasPrefix(p.Name, name+"-")
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolveProcesses(t *testing.T) {
+	enabled, disabled := true, false
+	count := func(n int) *int { return &n }
+	lifecycle := func() FeatureConfig {
+		var features FeatureConfig
+		features.Lifecycle.Enabled = true
+		return features
+	}
+	notifications := func() FeatureConfig {
+		var features FeatureConfig
+		features.BucketNotifications = BucketNotificationsFeatureConfig{
+			Enabled:         true,
+			DestinationAuth: NotificationAuthConfig{Type: notificationAuthNone},
+			Destinations:    []NotificationDestination{{Resource: "destination1"}, {Resource: "secured"}},
+		}
+		if err := features.BucketNotifications.resolve(8180); err != nil {
+			t.Fatal(err)
+		}
+		return features
+	}
+
+	tests := []struct {
+		name         string
+		features     FeatureConfig
+		processes    map[string]BackbeatProcessConfig
+		expected     []string
+		errorMessage string
+	}{
+		{
+			name:     "feature programs",
+			features: lifecycle(),
+			expected: []string{"lifecycle-conductor@0", "lifecycle-bucket-processor@1", "lifecycle-object-processor@2"},
+		},
+		{
+			name:      "extra instances and optional programs",
+			features:  lifecycle(),
+			processes: map[string]BackbeatProcessConfig{"lifecycle-bucket-processor": {Count: count(2)}, "garbage-collector": {Enabled: &enabled}},
+			expected:  []string{"lifecycle-conductor@0", "lifecycle-bucket-processor@1", "lifecycle-bucket-processor-2@8", "lifecycle-object-processor@2", "garbage-collector@9"},
+		},
+		{
+			name:      "disabled program",
+			features:  lifecycle(),
+			processes: map[string]BackbeatProcessConfig{"lifecycle-conductor": {Enabled: &disabled}, "lifecycle-object-processor": {Count: count(0)}},
+			expected:  []string{"lifecycle-bucket-processor@1"},
+		},
+		{
+			name:      "custom program",
+			features:  lifecycle(),
+			processes: map[string]BackbeatProcessConfig{"my-task": {Enabled: &enabled, Script: "my_task"}},
+			expected:  []string{"lifecycle-conductor@0", "lifecycle-bucket-processor@1", "lifecycle-object-processor@2", "my-task@8"},
+		},
+		{
+			name:     "notification processor per destination",
+			features: notifications(),
+			expected: []string{"notification-populator@3", "notification-processor-destination1@4", "notification-processor-secured@8"},
+		},
+		{
+			name:         "singleton with several instances",
+			features:     lifecycle(),
+			processes:    map[string]BackbeatProcessConfig{"lifecycle-conductor": {Count: count(2)}},
+			errorMessage: "runs a single instance",
+		},
+		{
+			name:         "too many instances",
+			features:     lifecycle(),
+			processes:    map[string]BackbeatProcessConfig{"lifecycle-bucket-processor": {Count: count(backbeatMaxCount + 1)}},
+			errorMessage: "must be between 0 and",
+		},
+		{
+			name:         "invalid name",
+			features:     lifecycle(),
+			processes:    map[string]BackbeatProcessConfig{"My_Task": {Script: "task"}},
+			errorMessage: "invalid process name",
+		},
+		{
+			name:         "custom program without script",
+			features:     lifecycle(),
+			processes:    map[string]BackbeatProcessConfig{"my-task": {Enabled: &enabled}},
+			errorMessage: "needs a script",
+		},
+		{
+			name:         "optional program of a disabled feature",
+			features:     lifecycle(),
+			processes:    map[string]BackbeatProcessConfig{"crr-failed-consumer": {Enabled: &enabled}},
+			errorMessage: "needs the cross_region_replication feature",
+		},
+		{
+			name:         "custom program without backbeat",
+			processes:    map[string]BackbeatProcessConfig{"my-task": {Enabled: &enabled, Script: "my_task"}},
+			errorMessage: "needs backbeat",
+		},
+		{
+			name:         "invalid env",
+			features:     lifecycle(),
+			processes:    map[string]BackbeatProcessConfig{"lifecycle-conductor": {Env: map[string]string{"A": `"quoted"`}}},
+			errorMessage: "invalid env of lifecycle-conductor",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := BackbeatConfig{Processes: tt.processes}
+			err := c.resolveProcesses(tt.features)
+			if tt.errorMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Fatalf("expected an error containing %q, got %v", tt.errorMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var programs []string
+			for _, p := range c.Programs {
+				programs = append(programs, fmt.Sprintf("%s@%d", p.Name, p.Offset))
+			}
+			if !slices.Equal(programs, tt.expected) {
+				t.Errorf("unexpected programs\n got: %q\nwant: %q", programs, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBackbeatEnv(t *testing.T) {
+	env, err := backbeatEnv(map[string]string{"B": "default", "A": "1"}, map[string]string{"B": "100%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `A="1",B="100%%"`; env != expected {
+		t.Errorf("got %s, want %s", env, expected)
+	}
+}
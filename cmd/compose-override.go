@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const composeOverrideFile = "docker-compose.override.yaml"
+
+// composeOverride is written to docker-compose.override.yaml, which docker
+// compose merges on top of docker-compose.yaml. It holds the service changes
+// derived from values.yaml so docker-compose.yaml can stay static.
+type composeOverride struct {
+	Services map[string]*composeOverrideService `yaml:"services"`
+	Volumes  map[string]struct{}                `yaml:"volumes,omitempty"`
+}
+
+type composeOverrideService struct {
+	// Image, Build, ContainerName, Restart, NetworkMode, Healthcheck and
+	// Profiles are set for the services only declared in the override
+	Image         string              `yaml:"image,omitempty"`
+	Build         *composeBuild       `yaml:"build,omitempty"`
+	ContainerName string              `yaml:"container_name,omitempty"`
+	Restart       string              `yaml:"restart,omitempty"`
+	NetworkMode   string              `yaml:"network_mode,omitempty"`
+	Healthcheck   *composeHealthcheck `yaml:"healthcheck,omitempty"`
+
+	Command     string            `yaml:"command,omitempty"`
+	WorkingDir  string            `yaml:"working_dir,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+	Volumes     []string          `yaml:"volumes,omitempty"`
+	UsernsMode  string            `yaml:"userns_mode,omitempty"`
+	Profiles    []string          `yaml:"profiles,omitempty"`
+}
+
+type composeHealthcheck struct {
+	Test     []string `yaml:"test"`
+	Interval string   `yaml:"interval,omitempty"`
+	Retries  int      `yaml:"retries,omitempty"`
+	Timeout  string   `yaml:"timeout,omitempty"`
+}
+
+// service returns the override of a service, creating it if needed.
+func (o *composeOverride) service(name string) *composeOverrideService {
+	svc, ok := o.Services[name]
+	if !ok {
+		svc = &composeOverrideService{}
+		o.Services[name] = svc
+	}
+	return svc
+}
+
+func generateComposeOverride(cfg EnvironmentConfig, envDir string) error {
+	override := composeOverride{Services: map[string]*composeOverrideService{}}
+
+	if err := addNodeOverrides(cfg, &override); err != nil {
+		return err
+	}
+
+	addPersistenceOverrides(cfg, &override)
+	addNotificationOverrides(cfg, &override)
+	addLifecycleOverrides(cfg, &override)
+
+	if err := addKafkaOverrides(cfg, envDir, &override); err != nil {
+		return err
+	}
+
+	if err := addContainerEngineOverrides(envDir, currentContainerEngine(), &override); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBufferString("# Generated from values.yaml by workbench configure, do not edit.\n")
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(override); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", composeOverrideFile, err)
+	}
+
+	path := filepath.Join(envDir, composeOverrideFile)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// nodeService describes how to run a node service from compose.
+type nodeService struct {
+	name  string
+	dev   DevConfig
+	debug DebugConfig
+	// command builds the service command around the node invocation
+	command func(node string) string
+	// logsMount gives services without a logs/ mount somewhere to write profiles
+	logsMount string
+}
+
+// addNodeOverrides rewrites the commands of node services to run a local
+// source checkout (dev) or to expose the inspector and profiles (debug).
+func addNodeOverrides(cfg EnvironmentConfig, override *composeOverride) error {
+	services := []nodeService{
+		{
+			name:    "cloudserver",
+			dev:     cfg.Cloudserver.Dev,
+			debug:   cfg.Cloudserver.Debug,
+			command: func(node string) string { return node + " index.js" },
+		},
+		{
+			name:  "vault",
+			dev:   cfg.Vault.Dev,
+			debug: cfg.Vault.Debug,
+			command: func(node string) string {
+				return fmt.Sprintf(`sh -c "chmod 400 tests/utils/keyfile && %s --max-http-header-size=32768 vaultd.js"`, node)
+			},
+			logsMount: "./logs/vault:/logs:rw",
+		},
+		{
+			name:      "utapi",
+			debug:     cfg.Utapi.Debug,
+			command:   func(node string) string { return node + " server.js" },
+			logsMount: "./logs/utapi:/logs:rw",
+		},
+	}
+
+	for _, ns := range services {
+		if !ns.dev.Enabled() && !ns.debug.Enabled {
+			continue
+		}
+
+		svc := override.service(ns.name)
+		flags := ns.debug.nodeFlags(0)
+
+		node := "node"
+		if ns.dev.Enabled() {
+			if err := applyDevMount(svc, ns.dev); err != nil {
+				return fmt.Errorf("invalid %s dev config: %w", ns.name, err)
+			}
+			node += " --watch"
+		}
+
+		if ns.dev.Enabled() && ns.dev.Command != "" {
+			// A custom command gets the debug flags through NODE_OPTIONS
+			svc.Command = ns.dev.Command
+			if flags != "" {
+				svc.Environment = map[string]string{"NODE_OPTIONS": flags}
+			}
+		} else {
+			svc.Command = ns.command(joinCommand(node, flags))
+		}
+
+		if ns.debug.Enabled && ns.logsMount != "" {
+			svc.Volumes = append(svc.Volumes, ns.logsMount)
+		}
+	}
+
+	// backbeat keeps running supervisord, programs are restarted by the
+	// dev-reload program and get their debug flags from supervisord.conf.
+	if dev := cfg.Backbeat.Dev; dev.Enabled() {
+		svc := override.service("backbeat")
+		if err := applyDevMount(svc, dev); err != nil {
+			return fmt.Errorf("invalid backbeat dev config: %w", err)
+		}
+		svc.Volumes = append(svc.Volumes, "./config/backbeat/dev-reload.sh:/conf/dev-reload.sh:ro")
+	}
+
+	return nil
+}
+
+// persistentStores lists the state of the stack that is lost when its
+// containers are recreated, unless persistence is enabled.
+var persistentStores = []struct {
+	Service string
+	// Name of the named volume, or of the directory below persistence.path
+	Name   string
+	Target string
+	Env    map[string]string
+}{
+	{Service: "metadata-s3", Name: "metadata-s3-data", Target: "/mnt/standalone_workdir"},
+	{Service: "metadata-scuba", Name: "metadata-scuba-data", Target: "/mnt/standalone_workdir"},
+	{Service: "s3-data", Name: "s3-data", Target: "/data", Env: map[string]string{"S3DATAPATH": "/data"}},
+	{Service: "vault", Name: "vault-data", Target: "/usr/src/app/localData"},
+}
+
+// addPersistenceOverrides mounts the metadata raft storage, the file data
+// backend and vault's LevelDB on volumes or host directories.
+func addPersistenceOverrides(cfg EnvironmentConfig, override *composeOverride) {
+	if !cfg.Persistence.Enabled {
+		return
+	}
+
+	for _, store := range persistentStores {
+		svc := override.service(store.Service)
+
+		source := store.Name
+		if cfg.Persistence.Mode == persistenceModeHost {
+			source = filepath.Join(cfg.Persistence.Path, store.Name)
+		} else {
+			if override.Volumes == nil {
+				override.Volumes = map[string]struct{}{}
+			}
+			override.Volumes[store.Name] = struct{}{}
+		}
+		svc.Volumes = append(svc.Volumes, fmt.Sprintf("%s:%s", source, store.Target))
+
+		for key, value := range store.Env {
+			if svc.Environment == nil {
+				svc.Environment = map[string]string{}
+			}
+			svc.Environment[key] = value
+		}
+	}
+}
+
+// addNotificationOverrides creates the topics and SCRAM users of the
+// notification destinations using kafka-destination, mounts the certificates
+// of mutual TLS and declares the notification receiver when a webhook
+// destination sends its events to it.
+func addNotificationOverrides(cfg EnvironmentConfig, override *composeOverride) {
+	if !cfg.Features.BucketNotifications.Enabled {
+		return
+	}
+
+	svc := override.service("setup-kafka-destination")
+	svc.Environment = map[string]string{
+		"TOPICS_TO_CREATE": strings.Join(cfg.Features.BucketNotifications.localTopics(), " "),
+		"SCRAM_USERS":      strings.Join(cfg.Features.BucketNotifications.scramUsers(), " "),
+	}
+
+	if cfg.Features.BucketNotifications.usesSSL() {
+		tlsDir := "./config/kafka/" + kafkaTLSDir
+		destination := override.service("kafka-destination")
+		destination.Volumes = append(destination.Volumes, tlsDir+":/opt/kafka/config/tls:ro")
+		backbeat := override.service("backbeat")
+		backbeat.Volumes = append(backbeat.Volumes, tlsDir+":/conf/notification-tls:ro")
+	}
+
+	if !cfg.receiverEnabled() {
+		return
+	}
+	receiver := override.service("notification-receiver")
+	receiver.Image = "${NOTIFICATION_RECEIVER_IMAGE}"
+	receiver.ContainerName = "workbench-notification-receiver"
+	receiver.Restart = "on-failure"
+	receiver.NetworkMode = "host"
+	receiver.Command = "python3 -u /receiver/receiver.py"
+	receiver.Environment = map[string]string{"RECEIVER_PORT": "${NOTIFICATION_RECEIVER_PORT}"}
+	receiver.Healthcheck = &composeHealthcheck{
+		Test:     []string{"CMD", "python3", "-c", "import urllib.request, os; urllib.request.urlopen('http://127.0.0.1:' + os.environ['RECEIVER_PORT'] + '/_health')"},
+		Interval: "5s",
+		Retries:  10,
+		Timeout:  "5s",
+	}
+	receiver.Profiles = []string{"feature-notifications-receiver"}
+	receiver.Volumes = []string{
+		"./config/notification-receiver/receiver.py:/receiver/receiver.py:ro",
+		"./logs/notification-receiver:/logs:rw",
+	}
+}
+
+// addLifecycleOverrides passes the time progression factor of lifecycle to
+// cloudserver, backbeat gets it from config/backbeat/env.
+func addLifecycleOverrides(cfg EnvironmentConfig, override *composeOverride) {
+	factor := cfg.Features.Lifecycle.TimeProgressionFactor()
+	if factor == 1 {
+		return
+	}
+
+	svc := override.service("cloudserver")
+	if svc.Environment == nil {
+		svc.Environment = map[string]string{}
+	}
+	svc.Environment["TIME_PROGRESSION_FACTOR"] = strconv.Itoa(factor)
+}
+
+// createPersistentDirectories creates the host directories of persistence
+// in host mode. They are world writable as containers run as various users.
+func createPersistentDirectories(cfg EnvironmentConfig) error {
+	if !cfg.Persistence.Enabled || cfg.Persistence.Mode != persistenceModeHost {
+		return nil
+	}
+
+	for _, store := range persistentStores {
+		dir := filepath.Join(cfg.Persistence.Path, store.Name)
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return fmt.Errorf("failed to create persistence directory %s: %w", dir, err)
+		}
+		if err := os.Chmod(dir, 0777); err != nil {
+			return fmt.Errorf("failed to set permissions of %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// applyDevMount mounts the checkout of dev over the application directory.
+func applyDevMount(svc *composeOverrideService, dev DevConfig) error {
+	if info, err := os.Stat(dev.Source); err != nil || !info.IsDir() {
+		return fmt.Errorf("source %s is not a directory", dev.Source)
+	}
+
+	svc.WorkingDir = dev.Workdir
+	svc.Volumes = append(svc.Volumes, fmt.Sprintf("%s:%s", dev.Source, dev.Workdir))
+	if dev.NodeModules == "image" {
+		// An anonymous volume keeps the node_modules installed in the image
+		svc.Volumes = append(svc.Volumes, filepath.ToSlash(filepath.Join(dev.Workdir, "node_modules")))
+	}
+	return nil
+}
+
+func joinCommand(args ...string) string {
+	return strings.Join(strings.Fields(strings.Join(args, " ")), " ")
+}
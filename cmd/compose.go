@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFile is the subset of a Docker Compose file workbench needs to map
+// rendered configuration files to the services using them.
+type composeFile struct {
+	Name     string                    `yaml:"name"`
+	Services map[string]composeService `yaml:"services"`
+}
+
+type composeService struct {
+	ContainerName string        `yaml:"container_name"`
+	User          string        `yaml:"user"`
+	Profiles      []string      `yaml:"profiles"`
+	Volumes       []any         `yaml:"volumes"`
+	Build         *composeBuild `yaml:"build"`
+	// DependsOn is a list of services or a map of services to conditions
+	DependsOn any `yaml:"depends_on"`
+}
+
+type composeBuild struct {
+	Context string `yaml:"context"`
+}
+
+// loadComposeFile loads docker-compose.yaml, with the services only declared
+// in docker-compose.override.yaml.
+func loadComposeFile(envPath string) (composeFile, error) {
+	var compose composeFile
+
+	data, err := os.ReadFile(filepath.Join(envPath, "docker-compose.yaml"))
+	if err != nil {
+		return compose, fmt.Errorf("failed to read docker-compose.yaml: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &compose); err != nil {
+		return compose, fmt.Errorf("failed to parse docker-compose.yaml: %w", err)
+	}
+
+	data, err = os.ReadFile(filepath.Join(envPath, composeOverrideFile))
+	if os.IsNotExist(err) {
+		return compose, nil
+	}
+	if err != nil {
+		return compose, fmt.Errorf("failed to read %s: %w", composeOverrideFile, err)
+	}
+
+	var override composeFile
+	if err := yaml.Unmarshal(data, &override); err != nil {
+		return compose, fmt.Errorf("failed to parse %s: %w", composeOverrideFile, err)
+	}
+	for name, svc := range override.Services {
+		if _, ok := compose.Services[name]; !ok {
+			compose.Services[name] = svc
+		}
+	}
+
+	return compose, nil
+}
+
+// activeServices returns the services enabled by the given compose profiles.
+func (c composeFile) activeServices(profiles []string) []string {
+	var services []string
+	for name, svc := range c.Services {
+		if len(svc.Profiles) == 0 || slices.ContainsFunc(svc.Profiles, func(p string) bool {
+			return slices.Contains(profiles, p)
+		}) {
+			services = append(services, name)
+		}
+	}
+	sort.Strings(services)
+	return services
+}
+
+// resolveService returns the compose service matching a service name, a
+// container name (e.g. workbench-s3) or a container name without the
+// workbench- prefix (e.g. s3).
+func (c composeFile) resolveService(name string) (string, error) {
+	if _, ok := c.Services[name]; ok {
+		return name, nil
+	}
+	for service, svc := range c.Services {
+		if svc.ContainerName != "" && (svc.ContainerName == name || svc.ContainerName == "workbench-"+name) {
+			return service, nil
+		}
+	}
+	return "", fmt.Errorf("unknown service %q", name)
+}
+
+// dependencies returns the services the service depends on.
+func (s composeService) dependencies() []string {
+	var deps []string
+	switch d := s.DependsOn.(type) {
+	case []any:
+		for _, name := range d {
+			if name, ok := name.(string); ok {
+				deps = append(deps, name)
+			}
+		}
+	case map[string]any:
+		for name := range d {
+			deps = append(deps, name)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// startOrder groups services in the order they start in: each group only
+// depends on services of the previous ones. Dependencies outside of services
+// are ignored.
+func (c composeFile) startOrder(services []string) ([][]string, error) {
+	pending := slices.Clone(services)
+	sort.Strings(pending)
+	started := map[string]bool{}
+
+	var groups [][]string
+	for len(pending) > 0 {
+		var group, rest []string
+		for _, name := range pending {
+			ready := true
+			for _, dep := range c.Services[name].dependencies() {
+				if slices.Contains(services, dep) && !started[dep] {
+					ready = false
+				}
+			}
+			if ready {
+				group = append(group, name)
+			} else {
+				rest = append(rest, name)
+			}
+		}
+		if len(group) == 0 {
+			return nil, fmt.Errorf("dependency cycle between services %s", strings.Join(rest, ", "))
+		}
+		for _, name := range group {
+			started[name] = true
+		}
+		groups = append(groups, group)
+		pending = rest
+	}
+	return groups, nil
+}
+
+// bindMounts returns the absolute host paths bind mounted into the service.
+// Named volumes are ignored.
+func (s composeService) bindMounts(envPath string) []string {
+	var mounts []string
+	for _, volume := range s.Volumes {
+		var source string
+		switch v := volume.(type) {
+		case string:
+			source, _, _ = strings.Cut(v, ":")
+		case map[string]any:
+			if v["type"] != "bind" {
+				continue
+			}
+			source, _ = v["source"].(string)
+		}
+
+		if !strings.HasPrefix(source, ".") && !filepath.IsAbs(source) {
+			continue
+		}
+		mounts = append(mounts, resolveComposePath(envPath, source))
+	}
+	return mounts
+}
+
+// buildContext returns the absolute path of the service's build context, or
+// an empty string if the service uses a prebuilt image.
+func (s composeService) buildContext(envPath string) string {
+	if s.Build == nil || s.Build.Context == "" {
+		return ""
+	}
+	return resolveComposePath(envPath, s.Build.Context)
+}
+
+func resolveComposePath(envPath, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(envPath, path)
+}
+
+// isOneShotService reports whether the service is a setup container that runs
+// to completion instead of staying up.
+func isOneShotService(name string) bool {
+	return strings.HasPrefix(name, "setup-")
+}
+
+// hashFiles returns the sha256 of every regular file below the given paths.
+// Missing paths are skipped.
+func hashFiles(paths ...string) (map[string]string, error) {
+	hashes := map[string]string{}
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			sum := sha256.Sum256(data)
+			hashes[path] = hex.EncodeToString(sum[:])
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", root, err)
+		}
+	}
+	return hashes, nil
+}
+
+// changedFiles returns the files added, modified or removed between two
+// results of hashFiles.
+func changedFiles(before, after map[string]string) []string {
+	var changed []string
+	for path, sum := range after {
+		if before[path] != sum {
+			changed = append(changed, path)
+		}
+	}
+	for path := range before {
+		if _, ok := after[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func isBelow(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// serviceChanges lists the services affected by a set of changed files.
+type serviceChanges struct {
+	// Restart holds services with a changed bind mounted file
+	Restart []string
+	// Rebuild holds services with a changed file in their build context
+	Rebuild []string
+}
+
+func (s serviceChanges) empty() bool {
+	return len(s.Restart) == 0 && len(s.Rebuild) == 0
+}
+
+// affectedServices maps changed files to the active services mounting them or
+// building an image from them.
+func affectedServices(compose composeFile, envPath string, profiles, changed []string) serviceChanges {
+	var changes serviceChanges
+	for _, name := range compose.activeServices(profiles) {
+		svc := compose.Services[name]
+
+		if buildContext := svc.buildContext(envPath); buildContext != "" {
+			if slices.ContainsFunc(changed, func(path string) bool { return isBelow(path, buildContext) }) {
+				changes.Rebuild = append(changes.Rebuild, name)
+				continue
+			}
+		}
+
+		for _, mount := range svc.bindMounts(envPath) {
+			if slices.ContainsFunc(changed, func(path string) bool { return isBelow(path, mount) }) {
+				changes.Restart = append(changes.Restart, name)
+				break
+			}
+		}
+	}
+	return changes
+}
+
+// applyServiceChanges brings the affected services up to date. Setup
+// containers are run again first, as some of them write into the rendered
+// configuration, then the long running services are recreated or restarted.
+func applyServiceChanges(ctx context.Context, orch Orchestrator, changes serviceChanges) error {
+	var setup, rebuild, restart []string
+	for _, name := range changes.Rebuild {
+		if isOneShotService(name) {
+			setup = append(setup, name)
+		} else {
+			rebuild = append(rebuild, name)
+		}
+	}
+	for _, name := range changes.Restart {
+		if isOneShotService(name) {
+			setup = append(setup, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+
+	if len(setup) > 0 {
+		opts := UpOptions{Services: setup, NoDeps: true, Build: true, ForceRecreate: true}
+		if err := orch.Up(ctx, opts); err != nil {
+			return fmt.Errorf("failed to run setup containers: %w", err)
+		}
+	}
+
+	if len(rebuild) > 0 {
+		opts := UpOptions{Services: rebuild, Detach: true, NoDeps: true, Build: true}
+		if err := orch.Up(ctx, opts); err != nil {
+			return fmt.Errorf("failed to rebuild services: %w", err)
+		}
+	}
+
+	if len(restart) > 0 {
+		if err := orch.Restart(ctx, restart); err != nil {
+			return fmt.Errorf("failed to restart services: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// runDockerCompose runs a docker compose command in the environment directory.
+func runDockerCompose(ctx context.Context, cfg EnvironmentConfig, envPath string, args ...string) error {
+	dockerComposeCmd := buildDockerComposeCommand(cfg, args...)
+
+	log.Info().Str("command", strings.Join(dockerComposeCmd, " ")).Msg("Running docker compose")
+
+	cmd := exec.CommandContext(ctx, dockerComposeCmd[0], dockerComposeCmd[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Dir = envPath
+	return cmd.Run()
+}
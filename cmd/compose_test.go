@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyServiceChanges(t *testing.T) {
+	orch := &fakeOrchestrator{containers: map[string]ContainerState{}}
+	changes := serviceChanges{
+		Rebuild: []string{"setup-vault", "cloudserver"},
+		Restart: []string{"backbeat", "setup-scuba"},
+	}
+	if err := applyServiceChanges(t.Context(), orch, changes); err != nil {
+		t.Fatal(err)
+	}
+	assertCalls(t, orch,
+		"up detach=false build=true pull= no-deps force-recreate setup-vault setup-scuba",
+		"up detach=true build=true pull= no-deps cloudserver",
+		"restart backbeat",
+	)
+}
+
+func TestStartOrder(t *testing.T) {
+	compose := composeFile{Services: map[string]composeService{
+		"setup-vault": {},
+		"vault":       {DependsOn: map[string]any{"setup-vault": map[string]any{"condition": "service_completed_successfully"}}},
+		"cloudserver": {DependsOn: []any{"vault", "metadata"}},
+		"redis":       {},
+		"cycle-a":     {DependsOn: []any{"cycle-b"}},
+		"cycle-b":     {DependsOn: []any{"cycle-a"}},
+	}}
+
+	tests := []struct {
+		name     string
+		services []string
+		expected [][]string
+		wantErr  bool
+	}{
+		{
+			name:     "dependencies first",
+			services: []string{"cloudserver", "redis", "setup-vault", "vault"},
+			expected: [][]string{{"redis", "setup-vault"}, {"vault"}, {"cloudserver"}},
+		},
+		{
+			name:     "missing dependencies are ignored",
+			services: []string{"cloudserver"},
+			expected: [][]string{{"cloudserver"}},
+		},
+		{
+			name:     "cycle",
+			services: []string{"cycle-a", "cycle-b", "redis"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := compose.startOrder(tt.services)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(groups, tt.expected) {
+				t.Errorf("got %q, want %q", groups, tt.expected)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"dario.cat/mergo"
+	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v3"
 )
 
@@ -44,22 +50,25 @@ func RuntimeConfigFromFlags(envDir, envName string) RuntimeConfig {
 }
 
 type EnvironmentConfig struct {
-	Global         GlobalConfig         `yaml:"global"`
-	Features       FeatureConfig        `yaml:"features"`
-	Cloudserver    CloudserverConfig    `yaml:"cloudserver"`
-	S3Metadata     MetadataConfig       `yaml:"s3_metadata"`
-	Backbeat       BackbeatConfig       `yaml:"backbeat"`
-	Vault          VaultConfig          `yaml:"vault"`
-	Scuba          ScubaConfig          `yaml:"scuba"`
-	ScubaMetadata  MetadataConfig       `yaml:"scuba_metadata"`
-	Kafka          KafkaConfig          `yaml:"kafka"`
-	Zookeeper      ZookeeperConfig      `yaml:"zookeeper"`
-	Redis          RedisConfig          `yaml:"redis"`
-	Utapi          UtapiConfig          `yaml:"utapi"`
-	MigrationTools MigrationToolsConfig `yaml:"migration_tools"`
-	Clickhouse     ClickhouseConfig     `yaml:"clickhouse"`
-	Fluentbit      FluentbitConfig      `yaml:"fluentbit"`
-	Nginx          NginxConfig          `yaml:"nginx"`
+	Global               GlobalConfig               `yaml:"global"`
+	Features             FeatureConfig              `yaml:"features"`
+	Cloudserver          CloudserverConfig          `yaml:"cloudserver"`
+	S3Metadata           MetadataConfig             `yaml:"s3_metadata"`
+	Backbeat             BackbeatConfig             `yaml:"backbeat"`
+	Vault                VaultConfig                `yaml:"vault"`
+	Scuba                ScubaConfig                `yaml:"scuba"`
+	ScubaMetadata        MetadataConfig             `yaml:"scuba_metadata"`
+	Kafka                KafkaConfig                `yaml:"kafka"`
+	Zookeeper            ZookeeperConfig            `yaml:"zookeeper"`
+	Redis                RedisConfig                `yaml:"redis"`
+	Utapi                UtapiConfig                `yaml:"utapi"`
+	MigrationTools       MigrationToolsConfig       `yaml:"migration_tools"`
+	Clickhouse           ClickhouseConfig           `yaml:"clickhouse"`
+	Fluentbit            FluentbitConfig            `yaml:"fluentbit"`
+	Nginx                NginxConfig                `yaml:"nginx"`
+	NotificationReceiver NotificationReceiverConfig `yaml:"notification_receiver"`
+	Persistence          PersistenceConfig          `yaml:"persistence"`
+	Registry             RegistryConfig             `yaml:"registry"`
 
 	HostUID int `yaml:"-"`
 	HostGID int `yaml:"-"`
@@ -81,6 +90,53 @@ type FeatureConfig struct {
 	RateLimiting           RateLimitingFeatureConfig           `yaml:"rate_limiting"`
 }
 
+// featureNames lists the keys of the features section of values.yaml.
+var featureNames = []string{
+	"scuba",
+	"bucket_notifications",
+	"cross_region_replication",
+	"utapi",
+	"migration",
+	"access_logging",
+	"s3_frontend",
+	"lifecycle",
+	"rate_limiting",
+}
+
+func (fc FeatureConfig) isEnabled(name string) bool {
+	switch name {
+	case "scuba":
+		return fc.Scuba.Enabled
+	case "bucket_notifications":
+		return fc.BucketNotifications.Enabled
+	case "cross_region_replication":
+		return fc.CrossRegionReplication.Enabled
+	case "utapi":
+		return fc.Utapi.Enabled
+	case "migration":
+		return fc.Migration.Enabled
+	case "access_logging":
+		return fc.AccessLogging.Enabled
+	case "s3_frontend":
+		return fc.S3Frontend.Enabled
+	case "lifecycle":
+		return fc.Lifecycle.Enabled
+	case "rate_limiting":
+		return fc.RateLimiting.Enabled
+	}
+	return false
+}
+
+func enabledFeatures(cfg EnvironmentConfig) []string {
+	features := []string{}
+	for _, name := range featureNames {
+		if cfg.Features.isEnabled(name) {
+			features = append(features, name)
+		}
+	}
+	return features
+}
+
 type S3FrontendFeatureConfig struct {
 	Enabled bool `yaml:"enabled"`
 }
@@ -91,12 +147,39 @@ type ScubaFeatureConfig struct {
 }
 
 type BucketNotificationsFeatureConfig struct {
-	Enabled         bool `yaml:"enabled"`
-	DestinationAuth struct {
-		Type     string `yaml:"type"`
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
-	} `yaml:"destinationAuth"`
+	Enabled bool `yaml:"enabled"`
+	// DestinationAuth is the auth of the kafka-destination listener on port
+	// 9094, and the default auth of the kafka destinations using it.
+	DestinationAuth NotificationAuthConfig `yaml:"destinationAuth"`
+	// Destinations default to a single kafka destination named destination1
+	// on kafka-destination.
+	Destinations []NotificationDestination `yaml:"destinations"`
+}
+
+type NotificationAuthConfig struct {
+	Type     string `yaml:"type"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// NotificationDestination is a target of bucket notifications, referenced
+// by its resource in the ARN of bucket notification configurations.
+type NotificationDestination struct {
+	Resource string `yaml:"resource"`
+	// Type is kafka or webhook
+	Type string `yaml:"type"`
+	// Host is the host:port of an external kafka broker. Kafka destinations
+	// without a host use kafka-destination.
+	Host  string                 `yaml:"host"`
+	Topic string                 `yaml:"topic"`
+	Auth  NotificationAuthConfig `yaml:"auth"`
+	// URL of a webhook destination, the notification receiver by default
+	URL string `yaml:"url"`
+
+	// Port of the kafka-destination listener of a local kafka destination
+	Port int `yaml:"-"`
+	// Offset is the inspector port offset of the processor of the destination
+	Offset int `yaml:"-"`
 }
 
 type CrossRegionReplicationFeatureConfig struct {
@@ -112,24 +195,132 @@ type MigrationFeatureConfig struct {
 }
 
 type CloudserverConfig struct {
-	Image                       string `yaml:"image"`
-	EnableNullVersionCompatMode bool   `yaml:"enableNullVersionCompatMode"`
-	LogLevel                    string `yaml:"log_level"`
+	Image                       string      `yaml:"image"`
+	EnableNullVersionCompatMode bool        `yaml:"enableNullVersionCompatMode"`
+	LogLevel                    string      `yaml:"log_level"`
+	Dev                         DevConfig   `yaml:"dev"`
+	Debug                       DebugConfig `yaml:"debug"`
 }
 
 type BackbeatConfig struct {
-	Image    string `yaml:"image"`
-	LogLevel string `yaml:"log_level"`
+	Image     string                           `yaml:"image"`
+	LogLevel  string                           `yaml:"log_level"`
+	Dev       DevConfig                        `yaml:"dev"`
+	Debug     DebugConfig                      `yaml:"debug"`
+	Processes map[string]BackbeatProcessConfig `yaml:"processes"`
+
+	// Programs are the supervisord programs resolved from Processes
+	Programs []BackbeatProcess `yaml:"-"`
+}
+
+// NodeOptions returns the npm flag passing node options to the backbeat
+// program at the given offset. Each program gets its own inspector port.
+func (c BackbeatConfig) NodeOptions(offset int) string {
+	flags := c.Debug.nodeFlags(offset)
+	if flags == "" {
+		return ""
+	}
+	return fmt.Sprintf(" --node-options='%s'", flags)
 }
 
 type VaultConfig struct {
-	Image    string `yaml:"image"`
-	LogLevel string `yaml:"log_level"`
+	Image    string      `yaml:"image"`
+	LogLevel string      `yaml:"log_level"`
+	Dev      DevConfig   `yaml:"dev"`
+	Debug    DebugConfig `yaml:"debug"`
+}
+
+// DevConfig runs a component from a local source checkout instead of the
+// code shipped in its image.
+type DevConfig struct {
+	// Source is the path of the checkout, relative to the environment directory
+	Source string `yaml:"source"`
+	// Command replaces the default watch command of the component
+	Command string `yaml:"command"`
+	// InspectPort is a shorthand for debug.port
+	InspectPort uint16 `yaml:"inspect_port"`
+	// Workdir is where the checkout is mounted in the container
+	Workdir string `yaml:"workdir"`
+	// NodeModules selects the node_modules used: "image" or "source"
+	NodeModules string `yaml:"node_modules"`
+}
+
+func (c DevConfig) Enabled() bool {
+	return c.Source != ""
+}
+
+// DebugConfig exposes the node inspector of a component and optionally
+// writes CPU and heap profiles to the component's logs/ directory.
+type DebugConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Port     uint16 `yaml:"port"`
+	CPUProf  bool   `yaml:"cpu_prof"`
+	HeapProf bool   `yaml:"heap_prof"`
+}
+
+// debugProfileDir is where profiles are written, below the /logs mount.
+const debugProfileDir = "/logs/profiles"
+
+// nodeFlags returns the node flags for the process at the given port offset,
+// or an empty string if debugging is disabled.
+func (c DebugConfig) nodeFlags(offset int) string {
+	if !c.Enabled {
+		return ""
+	}
+
+	flags := []string{fmt.Sprintf("--inspect=0.0.0.0:%d", int(c.Port)+offset)}
+	if c.CPUProf {
+		flags = append(flags, "--cpu-prof", "--cpu-prof-dir="+debugProfileDir)
+	}
+	if c.HeapProf {
+		flags = append(flags, "--heap-prof", "--heap-prof-dir="+debugProfileDir)
+	}
+	return strings.Join(flags, " ")
+}
+
+func (c *DebugConfig) resolve(dev DevConfig, defaultPort uint16) {
+	if dev.Enabled() && dev.InspectPort != 0 && c.Port == 0 {
+		c.Enabled = true
+		c.Port = dev.InspectPort
+	}
+	if c.Port == 0 {
+		c.Port = defaultPort
+	}
+}
+
+func (c *DevConfig) resolve(baseDir string) error {
+	if !c.Enabled() {
+		return nil
+	}
+
+	if !filepath.IsAbs(c.Source) {
+		c.Source = filepath.Join(baseDir, c.Source)
+	}
+	source, err := filepath.Abs(c.Source)
+	if err != nil {
+		return fmt.Errorf("failed to resolve dev source %s: %w", c.Source, err)
+	}
+	c.Source = source
+
+	if c.Workdir == "" {
+		c.Workdir = "/usr/src/app"
+	}
+
+	switch c.NodeModules {
+	case "":
+		c.NodeModules = "image"
+	case "image", "source":
+	default:
+		return fmt.Errorf("unknown dev node_modules %q (valid values: image, source)", c.NodeModules)
+	}
+
+	return nil
 }
 
 type UtapiConfig struct {
-	Image    string `yaml:"image"`
-	LogLevel string `yaml:"log_level"`
+	Image    string      `yaml:"image"`
+	LogLevel string      `yaml:"log_level"`
+	Debug    DebugConfig `yaml:"debug"`
 }
 
 type MigrationToolsConfig struct {
@@ -206,13 +397,44 @@ type MigrationConfig struct {
 }
 
 type ScubaConfig struct {
-	Image    string `yaml:"image"`
-	LogLevel string `yaml:"log_level"`
+	Image    string      `yaml:"image"`
+	LogLevel string      `yaml:"log_level"`
+	Debug    DebugConfig `yaml:"debug"`
+}
+
+// NodeFlags returns the node flags of the scuba process at the given offset,
+// prefixed with a space when not empty.
+func (c ScubaConfig) NodeFlags(offset int) string {
+	if flags := c.Debug.nodeFlags(offset); flags != "" {
+		return " " + flags
+	}
+	return ""
 }
 
 type KafkaConfig struct {
 	Image    string `yaml:"image"`
 	LogLevel string `yaml:"log_level"`
+	// BaseImage is the base image of the locally built kafka image
+	BaseImage string `yaml:"base_image"`
+	// Mode is zookeeper or kraft, the metadata mode of the backbeat cluster
+	// and kafka-destination
+	Mode string `yaml:"mode"`
+	// Brokers is the number of brokers of the backbeat cluster
+	Brokers int `yaml:"brokers"`
+	// Partitions, ReplicationFactor and Retention apply to the backbeat
+	// topics without settings in Topics
+	Partitions        int    `yaml:"partitions"`
+	ReplicationFactor int    `yaml:"replication_factor"`
+	Retention         string `yaml:"retention"`
+	// Topics holds the settings of backbeat topics, other topics are created
+	// as well
+	Topics map[string]KafkaTopicConfig `yaml:"topics"`
+}
+
+type KafkaTopicConfig struct {
+	Partitions        int    `yaml:"partitions"`
+	ReplicationFactor int    `yaml:"replication_factor"`
+	Retention         string `yaml:"retention"`
 }
 
 type ZookeeperConfig struct {
@@ -245,8 +467,125 @@ type NginxConfig struct {
 	SSLPort  uint16 `yaml:"ssl_port"`
 }
 
+// NotificationReceiverConfig is the HTTP server recording the events of
+// webhook destinations without a URL.
+type NotificationReceiverConfig struct {
+	Image string `yaml:"image"`
+	Port  uint16 `yaml:"port"`
+}
+
+// PersistenceConfig keeps metadata, object data and vault accounts across
+// container recreations.
+type PersistenceConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Mode is "volume" for named docker volumes or "host" for directories
+	Mode string `yaml:"mode"`
+	// Path is the base directory of host mode, relative to the environment directory
+	Path string `yaml:"path"`
+}
+
+const (
+	persistenceModeVolume = "volume"
+	persistenceModeHost   = "host"
+)
+
+func (c *PersistenceConfig) resolve(baseDir string) error {
+	switch c.Mode {
+	case "":
+		c.Mode = persistenceModeVolume
+	case persistenceModeVolume, persistenceModeHost:
+	default:
+		return fmt.Errorf("unknown persistence mode %q (valid modes: %s, %s)", c.Mode, persistenceModeVolume, persistenceModeHost)
+	}
+
+	if c.Path == "" {
+		c.Path = "data"
+	}
+	if !filepath.IsAbs(c.Path) {
+		c.Path = filepath.Join(baseDir, c.Path)
+	}
+	path, err := filepath.Abs(c.Path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve persistence path %s: %w", c.Path, err)
+	}
+	c.Path = path
+	return nil
+}
+
+// RegistryConfig redirects the images of the stack to other registries,
+// e.g. an internal mirror.
+type RegistryConfig struct {
+	Rewrite []RegistryRewriteRule `yaml:"rewrite"`
+}
+
+// RegistryRewriteRule replaces the From prefix of image references with To.
+// Docker Hub images are matched by their full name, e.g. docker.io/library/redis.
+type RegistryRewriteRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+func (c RegistryConfig) validate() error {
+	for i, rule := range c.Rewrite {
+		if rule.From == "" {
+			return fmt.Errorf("rewrite rule %d has no from prefix", i)
+		}
+	}
+	return nil
+}
+
+// rewrite applies the rule with the longest matching prefix to an image
+// reference. References matching no rule are returned unchanged.
+func (c RegistryConfig) rewrite(ref string) string {
+	if ref == "" {
+		return ref
+	}
+
+	name := normalizeImageName(ref)
+	var match *RegistryRewriteRule
+	for i, rule := range c.Rewrite {
+		if strings.HasPrefix(name, rule.From) && (match == nil || len(rule.From) > len(match.From)) {
+			match = &c.Rewrite[i]
+		}
+	}
+	if match == nil {
+		return ref
+	}
+	return match.To + strings.TrimPrefix(name, match.From)
+}
+
 type LifecycleFeatureConfig struct {
 	Enabled bool `yaml:"enabled"`
+	// CronRule schedules the runs of the conductor, with an optional seconds
+	// field
+	CronRule                   string `yaml:"cron_rule"`
+	BucketProcessorConcurrency int    `yaml:"bucket_processor_concurrency"`
+	// DaysAsSeconds makes a day of the lifecycle rules last a second in
+	// cloudserver and backbeat, for testing expirations and transitions
+	DaysAsSeconds bool `yaml:"days_as_seconds"`
+}
+
+// lifecycleDaysAsSecondsFactor is the time progression factor turning days
+// into seconds.
+const lifecycleDaysAsSecondsFactor = 24 * 60 * 60
+
+func (c LifecycleFeatureConfig) validate() error {
+	if fields := strings.Fields(c.CronRule); (len(fields) != 5 && len(fields) != 6) || strings.ContainsAny(c.CronRule, `"\`) {
+		return fmt.Errorf("invalid cron_rule %q, use 5 fields or 6 with seconds, e.g. */5 * * * * *", c.CronRule)
+	}
+	if c.BucketProcessorConcurrency < 1 {
+		return errors.New("bucket_processor_concurrency must be at least 1")
+	}
+	return nil
+}
+
+// TimeProgressionFactor is the TIME_PROGRESSION_FACTOR of cloudserver and
+// backbeat, dividing the duration of a day of the lifecycle rules.
+func (c LifecycleFeatureConfig) TimeProgressionFactor() int {
+	if c.DaysAsSeconds {
+		return lifecycleDaysAsSecondsFactor
+	}
+	return 1
 }
 
 type RateLimitingFeatureConfig struct {
@@ -272,6 +611,40 @@ type RateLimitingErrorConfig struct {
 	Message    string `yaml:"message"`
 }
 
+// imageRefs returns pointers to every image reference of the configuration,
+// in the same order as defaults.env followed by the base images of builds.
+func (cfg *EnvironmentConfig) imageRefs() []*string {
+	return []*string{
+		&cfg.Kafka.Image,
+		&cfg.Zookeeper.Image,
+		&cfg.Redis.Image,
+		&cfg.S3Metadata.Image,
+		&cfg.ScubaMetadata.Image,
+		&cfg.Cloudserver.Image,
+		&cfg.Vault.Image,
+		&cfg.Scuba.Image,
+		&cfg.Backbeat.Image,
+		&cfg.Utapi.Image,
+		&cfg.MigrationTools.Image,
+		&cfg.Clickhouse.Image,
+		&cfg.Fluentbit.Image,
+		&cfg.Nginx.Image,
+		&cfg.Kafka.BaseImage,
+		&cfg.NotificationReceiver.Image,
+	}
+}
+
+// images returns the deduplicated list of images referenced by the configuration.
+func (cfg EnvironmentConfig) images() []string {
+	images := []string{}
+	for _, ref := range cfg.imageRefs() {
+		if *ref != "" && !slices.Contains(images, *ref) {
+			images = append(images, *ref)
+		}
+	}
+	return images
+}
+
 func DefaultEnvironmentConfig() EnvironmentConfig {
 	return EnvironmentConfig{
 		Global: GlobalConfig{
@@ -279,12 +652,8 @@ func DefaultEnvironmentConfig() EnvironmentConfig {
 		},
 		Features: FeatureConfig{
 			BucketNotifications: BucketNotificationsFeatureConfig{
-				DestinationAuth: struct {
-					Type     string `yaml:"type"`
-					Username string `yaml:"username"`
-					Password string `yaml:"password"`
-				}{
-					Type: "none",
+				DestinationAuth: NotificationAuthConfig{
+					Type: notificationAuthNone,
 				},
 			},
 			Utapi: UtapiFeatureConfig{
@@ -300,7 +669,9 @@ func DefaultEnvironmentConfig() EnvironmentConfig {
 				Enabled: false,
 			},
 			Lifecycle: LifecycleFeatureConfig{
-				Enabled: false,
+				Enabled:                    false,
+				CronRule:                   "*/5 * * * * *",
+				BucketProcessorConcurrency: 10,
 			},
 			RateLimiting: RateLimitingFeatureConfig{
 				Enabled: false,
@@ -347,6 +718,14 @@ func DefaultEnvironmentConfig() EnvironmentConfig {
 			},
 			RaftSessions: 1,
 		},
+		Kafka: KafkaConfig{
+			BaseImage:         "alpine:latest",
+			Mode:              kafkaModeZookeeper,
+			Brokers:           1,
+			Partitions:        1,
+			ReplicationFactor: 1,
+			Retention:         "168h",
+		},
 		Utapi:          UtapiConfig{},
 		MigrationTools: MigrationToolsConfig{},
 		Clickhouse:     ClickhouseConfig{},
@@ -355,6 +734,9 @@ func DefaultEnvironmentConfig() EnvironmentConfig {
 			HTTPPort: 80,
 			SSLPort:  443,
 		},
+		NotificationReceiver: NotificationReceiverConfig{
+			Port: 8180,
+		},
 	}
 }
 
@@ -373,6 +755,15 @@ func LoadEnvironmentConfig(path string) (EnvironmentConfig, error) {
 		return cfg, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	// The overrides of the last up or create-env are merged on top
+	overridesPath := filepath.Join(filepath.Dir(path), valuesOverridesFile)
+	if _, err := os.Stat(overridesPath); err == nil {
+		data, err = mergeValuesOverrides(data, overridesPath)
+		if err != nil {
+			return cfg, err
+		}
+	}
+
 	// Parse the YAML into a temporary config
 	var fileCfg EnvironmentConfig
 	if err := yaml.Unmarshal(data, &fileCfg); err != nil {
@@ -441,5 +832,167 @@ func LoadEnvironmentConfig(path string) (EnvironmentConfig, error) {
 		cfg.Fluentbit.LogLevel = cfg.Global.LogLevel
 	}
 
+	// Dev sources are relative to the environment directory
+	envDir := filepath.Dir(path)
+	for name, dev := range map[string]*DevConfig{
+		"cloudserver": &cfg.Cloudserver.Dev,
+		"vault":       &cfg.Vault.Dev,
+		"backbeat":    &cfg.Backbeat.Dev,
+	} {
+		if err := dev.resolve(envDir); err != nil {
+			return cfg, fmt.Errorf("invalid %s dev config: %w", name, err)
+		}
+	}
+
+	if err := cfg.Persistence.resolve(envDir); err != nil {
+		return cfg, fmt.Errorf("invalid persistence config: %w", err)
+	}
+
+	// Default inspector ports leave room for one port per process
+	cfg.Cloudserver.Debug.resolve(cfg.Cloudserver.Dev, 9229)
+	cfg.Vault.Debug.resolve(cfg.Vault.Dev, 9230)
+	cfg.Utapi.Debug.resolve(DevConfig{}, 9231)
+	cfg.Scuba.Debug.resolve(DevConfig{}, 9232)
+	cfg.Backbeat.Debug.resolve(cfg.Backbeat.Dev, 9240)
+
+	if err := cfg.Kafka.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid kafka config: %w", err)
+	}
+
+	if err := cfg.Features.BucketNotifications.resolve(cfg.NotificationReceiver.Port); err != nil {
+		return cfg, fmt.Errorf("invalid bucket notifications config: %w", err)
+	}
+
+	if cfg.Kafka.KRaft() && cfg.Features.BucketNotifications.Enabled && len(cfg.Features.BucketNotifications.scramUsers()) > 0 {
+		return cfg, errors.New("invalid bucket notifications config: scram auth needs kafka.mode zookeeper, kafka 3.4 can't create SCRAM users in KRaft mode")
+	}
+
+	if err := cfg.Features.Lifecycle.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid lifecycle config: %w", err)
+	}
+
+	if err := cfg.Backbeat.resolveProcesses(cfg.Features); err != nil {
+		return cfg, fmt.Errorf("invalid backbeat processes config: %w", err)
+	}
+
+	if err := validateDebugPorts(cfg); err != nil {
+		return cfg, fmt.Errorf("invalid debug config: %w", err)
+	}
+
+	if err := cfg.Registry.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid registry config: %w", err)
+	}
+	for _, ref := range cfg.imageRefs() {
+		*ref = cfg.Registry.rewrite(*ref)
+	}
+
 	return cfg, nil
 }
+
+// valuesOverridesFile holds the values files and features of the last up or
+// create-env given --values or --feature. It is merged on top of values.yaml
+// when loading the config, leaving values.yaml as written by the user.
+const valuesOverridesFile = "values.overrides.yaml"
+
+const valuesOverridesHeader = "# Generated by workbench from the --values and --feature flags of the last\n" +
+	"# up or create-env given any, edit values.yaml instead.\n"
+
+// writeValuesOverrides merges the given values files and enables the listed
+// features in the overrides file of the environment, replacing the previous
+// overrides. Without any, the previous overrides are kept unless reset.
+func writeValuesOverrides(envPath string, valuesFiles []string, features []string, reset bool) error {
+	path := filepath.Join(envPath, valuesOverridesFile)
+	if len(valuesFiles) == 0 && len(features) == 0 {
+		if reset {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			return nil
+		}
+		if _, err := os.Stat(path); err == nil {
+			log.Info().Msgf("Applying the values and features of an earlier run from %s, pass --reset-overrides to drop them", valuesOverridesFile)
+		}
+		return nil
+	}
+
+	values := map[string]any{}
+	for _, file := range valuesFiles {
+		override, err := readValuesMap(file)
+		if err != nil {
+			return err
+		}
+		mergeValuesMaps(values, override)
+	}
+
+	for _, feature := range features {
+		if !slices.Contains(featureNames, feature) {
+			return fmt.Errorf("unknown feature %q (valid features: %s)", feature, strings.Join(featureNames, ", "))
+		}
+		mergeValuesMaps(values, map[string]any{
+			"features": map[string]any{
+				feature: map[string]any{"enabled": true},
+			},
+		})
+	}
+
+	buf := bytes.NewBufferString(valuesOverridesHeader)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(values); err != nil {
+		return fmt.Errorf("failed to encode values: %w", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// mergeValuesOverrides returns the values of data with the overrides file at
+// path merged on top.
+func mergeValuesOverrides(data []byte, path string) ([]byte, error) {
+	values := map[string]any{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if values == nil {
+		values = map[string]any{}
+	}
+
+	overrides, err := readValuesMap(path)
+	if err != nil {
+		return nil, err
+	}
+	mergeValuesMaps(values, overrides)
+
+	return yaml.Marshal(values)
+}
+
+func readValuesMap(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values file: %w", err)
+	}
+
+	values := map[string]any{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// mergeValuesMaps recursively merges src into dst. Nested maps are merged,
+// any other value in src replaces the one in dst.
+func mergeValuesMaps(dst, src map[string]any) {
+	for key, srcVal := range src {
+		srcMap, srcIsMap := srcVal.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			mergeValuesMaps(dstMap, srcMap)
+			continue
+		}
+		dst[key] = srcVal
+	}
+}
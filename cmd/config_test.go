@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteValuesOverrides(t *testing.T) {
+	valuesFile := filepath.Join(t.TempDir(), "ci-values.yaml")
+	if err := os.WriteFile(valuesFile, []byte("kafka:\n  partitions: 3\nfeatures:\n  scuba:\n    enabled: false\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		previous []string
+		values   []string
+		features []string
+		reset    bool
+		// expected features of the loaded config, nil without overrides file
+		expected map[string]bool
+	}{
+		{name: "no overrides"},
+		{name: "features", features: []string{"scuba", "lifecycle"},
+			expected: map[string]bool{"scuba": true, "lifecycle": true}},
+		{name: "features win over values", values: []string{valuesFile}, features: []string{"scuba"},
+			expected: map[string]bool{"scuba": true}},
+		{name: "kept without flags", previous: []string{"utapi"},
+			expected: map[string]bool{"utapi": true}},
+		{name: "replaced by flags", previous: []string{"utapi"}, features: []string{"scuba"},
+			expected: map[string]bool{"scuba": true, "utapi": false}},
+		{name: "reset", previous: []string{"utapi"}, reset: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envPath := t.TempDir()
+			if err := os.WriteFile(filepath.Join(envPath, "values.yaml"), []byte("features:\n  utapi:\n    enabled: false\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.previous != nil {
+				if err := writeValuesOverrides(envPath, nil, tt.previous, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := writeValuesOverrides(envPath, tt.values, tt.features, tt.reset); err != nil {
+				t.Fatalf("writeValuesOverrides failed: %v", err)
+			}
+
+			_, err := os.Stat(filepath.Join(envPath, valuesOverridesFile))
+			if tt.expected == nil {
+				if !os.IsNotExist(err) {
+					t.Fatalf("expected no overrides file, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected an overrides file: %v", err)
+			}
+
+			cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for feature, enabled := range tt.expected {
+				if cfg.Features.isEnabled(feature) != enabled {
+					t.Errorf("expected %s enabled=%t", feature, enabled)
+				}
+			}
+			if len(tt.values) > 0 && cfg.Kafka.Partitions != 3 {
+				t.Errorf("expected the values file to set 3 partitions, got %d", cfg.Kafka.Partitions)
+			}
+		})
+	}
+}
+
+func TestWriteValuesOverridesUnknownFeature(t *testing.T) {
+	if err := writeValuesOverrides(t.TempDir(), nil, []string{"warp-drive"}, false); err == nil {
+		t.Fatal("expected an unknown feature to fail")
+	}
+}
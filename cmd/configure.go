@@ -6,11 +6,13 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"math/big"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -23,6 +25,41 @@ type ConfigureCmd struct {
 
 type configGenFunc func(cfg EnvironmentConfig, path string) error
 
+// component groups the generator of a component's configuration with the
+// long running compose services that use it.
+type component struct {
+	Name     string
+	Generate configGenFunc
+	Services []string
+}
+
+var components = []component{
+	{"cloudserver", generateCloudserverConfig, []string{"cloudserver", "s3-data"}},
+	{"backbeat", generateBackbeatConfig, []string{"backbeat"}},
+	{"vault", generateVaultConfig, []string{"vault"}},
+	{"scuba", generateScubaConfig, []string{"scuba"}},
+	{"metadata-s3", generateS3MetadataConfig, []string{"metadata-s3"}},
+	{"metadata-scuba", generateScubaMetadataConfig, []string{"metadata-scuba"}},
+	{"kafka", generateKafkaConfig, []string{"zookeeper", "kafka", "kafka-destination"}},
+	{"utapi", generateUtapiConfig, []string{"utapi"}},
+	{"migration-tools", generateMigrationToolsConfig, []string{"migration-tools"}},
+	{"clickhouse", generateClickhouseConfig, []string{"clickhouse-shard-1", "clickhouse-shard-2"}},
+	{"fluentbit", generateFluentbitConfig, []string{"fluentbit"}},
+	{"nginx", generateNginxConfig, []string{"s3-frontend"}},
+	{"notification-receiver", generateNotificationReceiverConfig, []string{"notification-receiver"}},
+}
+
+// findComponent returns the component with the given name, or the component
+// running the given compose service.
+func findComponent(name string) (component, bool) {
+	for _, c := range components {
+		if c.Name == name || slices.Contains(c.Services, name) {
+			return c, true
+		}
+	}
+	return component{}, false
+}
+
 func (c *ConfigureCmd) Run() error {
 	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
 	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
@@ -50,6 +87,9 @@ func createLogDirectories(envDir string) error {
 		filepath.Join(envDir, "logs", "backbeat"),
 		filepath.Join(envDir, "logs", "migration-tools"),
 		filepath.Join(envDir, "logs", "fluentbit"),
+		filepath.Join(envDir, "logs", "vault"),
+		filepath.Join(envDir, "logs", "utapi"),
+		filepath.Join(envDir, "logs", "notification-receiver"),
 	}
 
 	for _, dir := range logDirs {
@@ -60,9 +100,32 @@ func createLogDirectories(envDir string) error {
 	return nil
 }
 
+// prepareConfig applies the image lock and the container engine to the
+// config before rendering it, and checks that it can run on the volumes of
+// the environment.
+func prepareConfig(cfg EnvironmentConfig, envDir string) (EnvironmentConfig, containerEngine, error) {
+	cfg, err := applyImageLock(cfg, envDir)
+	if err != nil {
+		return cfg, containerEngine{}, err
+	}
+
+	engine := currentContainerEngine()
+	cfg = applyContainerEngine(cfg, engine)
+
+	if err := checkKafkaVolumesMode(cfg, envDir); err != nil {
+		return cfg, engine, err
+	}
+	return cfg, engine, nil
+}
+
 func configureEnv(cfg EnvironmentConfig, envDir string) error {
 	log.Info().Msgf("Configuring environment %s", envDir)
 
+	cfg, engine, err := prepareConfig(cfg, envDir)
+	if err != nil {
+		return err
+	}
+
 	if err := createLogDirectories(envDir); err != nil {
 		return fmt.Errorf("failed to create log directories: %w", err)
 	}
@@ -71,19 +134,12 @@ func configureEnv(cfg EnvironmentConfig, envDir string) error {
 		return fmt.Errorf("failed to generate defaults.env: %w", err)
 	}
 
-	components := []configGenFunc{
-		generateCloudserverConfig,
-		generateBackbeatConfig,
-		generateVaultConfig,
-		generateScubaConfig,
-		generateS3MetadataConfig,
-		generateScubaMetadataConfig,
-		generateKafkaConfig,
-		generateUtapiConfig,
-		generateMigrationToolsConfig,
-		generateClickhouseConfig,
-		generateFluentbitConfig,
-		generateNginxConfig,
+	if err := createPersistentDirectories(cfg); err != nil {
+		return err
+	}
+
+	if err := generateComposeOverride(cfg, envDir); err != nil {
+		return fmt.Errorf("failed to generate %s: %w", composeOverrideFile, err)
 	}
 
 	configDir := filepath.Join(envDir, "config")
@@ -94,10 +150,14 @@ func configureEnv(cfg EnvironmentConfig, envDir string) error {
 	}
 
 	for _, component := range components {
-		if err := component(cfg, configDir); err != nil {
+		if err := component.Generate(cfg, configDir); err != nil {
 			return fmt.Errorf("failed to generate config: %w", err)
 		}
 	}
+
+	if err := shareWritableMounts(envDir, engine); err != nil {
+		return fmt.Errorf("failed to set permissions of writable mounts: %w", err)
+	}
 	return nil
 }
 
@@ -145,11 +205,49 @@ func generateBackbeatConfig(cfg EnvironmentConfig, path string) error {
 		"supervisord.conf",
 		"config.json",
 		"config.notification.json",
-		"notificationCredentials.json",
 		"admin-backbeat.json",
+		"dev-reload.sh",
+	}
+
+	backbeatDir := filepath.Join(path, "backbeat")
+	if err := renderTemplates(cfg, "templates/backbeat", backbeatDir, templates); err != nil {
+		return err
 	}
 
-	return renderTemplates(cfg, "templates/backbeat", filepath.Join(path, "backbeat"), templates)
+	return writeNotificationCredentials(cfg, filepath.Join(backbeatDir, "notification-credentials"))
+}
+
+// writeNotificationCredentials writes the credentials file of each
+// notification destination using SASL auth.
+func writeNotificationCredentials(cfg EnvironmentConfig, dir string) error {
+	// Stale credentials of removed destinations must not linger
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	if !cfg.Features.BucketNotifications.Enabled {
+		return nil
+	}
+	for _, d := range cfg.Features.BucketNotifications.Destinations {
+		if d.Auth.Mechanism() == "" {
+			continue
+		}
+		data, err := json.MarshalIndent(struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		}{d.Auth.Username, d.Auth.Password}, "", "    ")
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, d.Resource+".json")
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
 }
 
 func generateVaultConfig(cfg EnvironmentConfig, path string) error {
@@ -192,13 +290,28 @@ func generateKafkaConfig(cfg EnvironmentConfig, path string) error {
 	templates := []string{
 		"Dockerfile",
 		"setup.sh",
-		"server.backbeat.properties",
 		"server.destination.properties",
 		"config.properties",
 		"zookeeper.properties",
 	}
 
-	return renderTemplates(cfg, "templates/kafka", filepath.Join(path, "kafka"), templates)
+	kafkaDir := filepath.Join(path, "kafka")
+	if err := renderTemplates(cfg, "templates/kafka", kafkaDir, templates); err != nil {
+		return err
+	}
+
+	for _, b := range cfg.Kafka.brokers() {
+		if err := renderTemplateToFile(
+			getTemplates(),
+			"templates/kafka/server.backbeat.properties",
+			kafkaBrokerConfig{EnvironmentConfig: cfg, Broker: b},
+			filepath.Join(kafkaDir, b.Properties),
+		); err != nil {
+			return fmt.Errorf("failed to render template %s: %w", b.Properties, err)
+		}
+	}
+
+	return generateKafkaTLS(cfg, filepath.Join(kafkaDir, kafkaTLSDir))
 }
 
 func generateUtapiConfig(cfg EnvironmentConfig, path string) error {
@@ -244,6 +357,14 @@ func generateFluentbitConfig(cfg EnvironmentConfig, path string) error {
 	return renderTemplates(cfg, "templates/fluentbit", filepath.Join(path, "fluentbit"), templates)
 }
 
+func generateNotificationReceiverConfig(cfg EnvironmentConfig, path string) error {
+	if !cfg.receiverEnabled() {
+		return nil
+	}
+
+	return renderTemplates(cfg, "templates/notification-receiver", filepath.Join(path, "notification-receiver"), []string{"receiver.py"})
+}
+
 func generateNginxConfig(cfg EnvironmentConfig, path string) error {
 	if !cfg.Features.S3Frontend.Enabled {
 		return nil
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	containerEngineAuto          = "auto"
+	containerEngineDocker        = "docker"
+	containerEngineDockerCompose = "docker-compose"
+	containerEnginePodman        = "podman"
+)
+
+// containerEngine is the container engine running the environment.
+type containerEngine struct {
+	// CLI is the engine command, docker or podman
+	CLI string
+	// Compose is the command running compose files, e.g. docker compose
+	Compose []string
+	// Rootless engines run in a user namespace, root in containers is the
+	// user running the engine and other users are mapped to subordinate ids.
+	Rootless bool
+}
+
+func (e containerEngine) isPodman() bool {
+	return e.CLI == containerEnginePodman
+}
+
+// command returns the engine command with args, e.g. docker volume ls.
+func (e containerEngine) command(args ...string) []string {
+	return append([]string{e.CLI}, args...)
+}
+
+var (
+	detectedEngine   containerEngine
+	detectEngineOnce sync.Once
+)
+
+// currentContainerEngine returns the engine selected with --container-engine,
+// detecting it on first use.
+func currentContainerEngine() containerEngine {
+	detectEngineOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		detectedEngine = detectContainerEngine(ctx, CLI.ContainerEngine)
+		log.Debug().
+			Strs("compose", detectedEngine.Compose).
+			Bool("rootless", detectedEngine.Rootless).
+			Msg("Using container engine")
+	})
+	return detectedEngine
+}
+
+func detectContainerEngine(ctx context.Context, name string) containerEngine {
+	if name == "" || name == containerEngineAuto {
+		name = detectEngineName(ctx)
+	}
+
+	var engine containerEngine
+	switch name {
+	case containerEngineDockerCompose:
+		engine = containerEngine{CLI: "docker", Compose: []string{"docker-compose"}}
+	case containerEnginePodman:
+		engine = containerEngine{CLI: "podman", Compose: []string{"podman", "compose"}}
+	default:
+		engine = containerEngine{CLI: "docker", Compose: []string{"docker", "compose"}}
+	}
+	engine.Rootless = engineRootless(ctx, engine)
+	return engine
+}
+
+// detectEngineName prefers the docker compose plugin, then the standalone
+// docker-compose, then podman. Errors of a missing engine are left to the
+// commands using it.
+func detectEngineName(ctx context.Context) string {
+	if _, err := exec.LookPath("docker"); err == nil {
+		// podman-docker installs a docker command running podman
+		out, _ := exec.CommandContext(ctx, "docker", "--version").Output()
+		if strings.HasPrefix(strings.ToLower(string(out)), "podman") {
+			return containerEnginePodman
+		}
+		if exec.CommandContext(ctx, "docker", "compose", "version").Run() == nil {
+			return containerEngineDocker
+		}
+	}
+	if _, err := exec.LookPath("docker-compose"); err == nil {
+		return containerEngineDockerCompose
+	}
+	if _, err := exec.LookPath("podman"); err == nil {
+		return containerEnginePodman
+	}
+	return containerEngineDocker
+}
+
+func engineRootless(ctx context.Context, engine containerEngine) bool {
+	if engine.isPodman() {
+		out, err := exec.CommandContext(ctx, "podman", "info", "--format", "{{.Host.Security.Rootless}}").Output()
+		return err == nil && strings.TrimSpace(string(out)) == "true"
+	}
+
+	out, err := exec.CommandContext(ctx, "docker", "info", "--format", "{{json .SecurityOptions}}").Output()
+	return err == nil && strings.Contains(string(out), "rootless")
+}
+
+// applyContainerEngine adapts cfg to the user namespace of rootless Docker:
+// root in containers is the user running the engine, while HOST_UID would
+// be mapped to a subordinate id that can't write the environment files.
+// Podman keeps HOST_UID with the keep-id user namespace, see
+// addContainerEngineOverrides.
+func applyContainerEngine(cfg EnvironmentConfig, engine containerEngine) EnvironmentConfig {
+	if engine.Rootless && !engine.isPodman() {
+		cfg.HostUID = 0
+		cfg.HostGID = 0
+	}
+	return cfg
+}
+
+// addContainerEngineOverrides runs the services using HOST_UID with the host
+// user mapped to itself on rootless podman.
+func addContainerEngineOverrides(envDir string, engine containerEngine, override *composeOverride) error {
+	if !engine.Rootless || !engine.isPodman() {
+		return nil
+	}
+
+	compose, err := loadComposeFile(envDir)
+	if err != nil {
+		return err
+	}
+	for name, svc := range compose.Services {
+		if strings.Contains(svc.User, "HOST_UID") {
+			override.service(name).UsernsMode = "keep-id"
+		}
+	}
+	return nil
+}
+
+// shareWritableMounts makes the directories written by containers writable
+// by the subordinate ids of containers not running as root on rootless
+// engines.
+func shareWritableMounts(envDir string, engine containerEngine) error {
+	if !engine.Rootless {
+		return nil
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(envDir, "logs", "*"))
+	if err != nil {
+		return err
+	}
+	dirs = append(dirs, filepath.Join(envDir, "logs"), filepath.Join(envDir, "config", "backbeat"))
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := os.Chmod(dir, 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,11 +9,14 @@ import (
 )
 
 type CreateEnvCmd struct {
-	EnvDir            string `help:"Directory to create the environment in. default: './env'" short:"d"`
-	Name              string `help:"Name of the environment to create. default: 'default'" short:"n"`
-	Overwrite         bool   `help:"Overwrite the environment if it already exists." short:"o"`
-	WithConfig        string `help:"Path to a custom configuration file. Replaces the default config." type:"existingfile"`
-	WithDockerCompose string `help:"Path to a custom Docker Compose file. Replaces the default file." type:"existingfile"`
+	EnvDir            string   `help:"Directory to create the environment in. default: './env'" short:"d"`
+	Name              string   `help:"Name of the environment to create. default: 'default'" short:"n"`
+	Overwrite         bool     `help:"Overwrite the environment if it already exists." short:"o"`
+	WithConfig        string   `help:"Path to a custom configuration file. Replaces the default config." type:"existingfile"`
+	WithDockerCompose string   `help:"Path to a custom Docker Compose file. Replaces the default file." type:"existingfile"`
+	Values            []string `help:"Path to a values file merged on top of the environment's values.yaml until the next run given --values or --feature. Can be repeated." type:"existingfile"`
+	Feature           []string `help:"Enable a feature on top of the environment's values.yaml until the next run given --values or --feature (e.g. scuba,lifecycle). Can be repeated."`
+	ResetOverrides    bool     `help:"Drop the values and features of earlier --values and --feature flags."`
 }
 
 func (c *CreateEnvCmd) Run() error {
@@ -23,14 +26,19 @@ func (c *CreateEnvCmd) Run() error {
 		return fmt.Errorf("failed to create environment: %w", err)
 	}
 
+	cfgPath := filepath.Join(envPath, "values.yaml")
+	if err := writeValuesOverrides(envPath, c.Values, c.Feature, c.ResetOverrides || c.Overwrite); err != nil {
+		return fmt.Errorf("failed to apply values overrides: %w", err)
+	}
+
 	var cfg EnvironmentConfig
-	if c.WithConfig != "" {
+	if c.WithConfig != "" && len(c.Values) == 0 && len(c.Feature) == 0 {
 		cfg, err = LoadEnvironmentConfig(c.WithConfig)
 		if err != nil {
 			return fmt.Errorf("failed to load custom config: %w", err)
 		}
 	} else {
-		cfg, err = LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+		cfg, err = LoadEnvironmentConfig(cfgPath)
 		if err != nil {
 			return fmt.Errorf("failed to load config: %w", err)
 		}
@@ -5,11 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strings"
 	"syscall"
+
+	"github.com/rs/zerolog/log"
 )
 
 type DestroyCmd struct {
@@ -36,29 +36,84 @@ func (c *DestroyCmd) Run() error {
 		return err
 	}
 
-	args := []string{"down", "--volumes", "--timeout", fmt.Sprintf("%d", c.Timeout)}
-
-	dockerComposeCmd := buildDockerComposeCommand(cfg, args...)
-
-	fmt.Println(strings.Join(dockerComposeCmd, " "))
-
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, dockerComposeCmd[0], dockerComposeCmd[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = envPath
-	if err := cmd.Run(); err != nil {
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	if err := orch.Down(ctx, DownOptions{Timeout: c.Timeout, Volumes: true}); err != nil {
 		if errors.Is(ctx.Err(), context.Canceled) {
 			return nil
 		}
 		return err
 	}
 
+	if err := removePersistentData(ctx, cfg, orch); err != nil {
+		return fmt.Errorf("failed to remove persistent data: %w", err)
+	}
+
 	if err := os.RemoveAll(envPath); err != nil {
 		return fmt.Errorf("failed to remove environment: %w", err)
 	}
 
 	return nil
 }
+
+// helperImageName runs maintenance commands on files owned by container users.
+const helperImageName = "alpine:3.20"
+
+// helperImage returns the helper image, from the registry mirror if any.
+func (cfg EnvironmentConfig) helperImage() string {
+	return cfg.Registry.rewrite(helperImageName)
+}
+
+// removePersistentData removes the host directories of persistence, which
+// may live outside of the environment. Only the directories of the stores are
+// removed, the path itself when nothing else is left in it. Named volumes are
+// removed by compose.
+func removePersistentData(ctx context.Context, cfg EnvironmentConfig, orch Orchestrator) error {
+	if !cfg.Persistence.Enabled || cfg.Persistence.Mode != persistenceModeHost {
+		return nil
+	}
+
+	path := cfg.Persistence.Path
+	var remaining []string
+	for _, store := range persistentStores {
+		dir := filepath.Join(path, store.Name)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn().Err(err).Str("path", dir).Msg("Failed to remove persistent data, retrying from a container")
+			remaining = append(remaining, store.Name)
+		}
+	}
+
+	if len(remaining) > 0 {
+		// Files written by containers running as root can't be removed by the
+		// user, the stores are removed as root within the mounted path
+		opts := RunOptions{
+			Image:   cfg.helperImage(),
+			Volumes: []string{path + ":/data"},
+			Command: []string{"rm", "-rf"},
+			Stdout:  os.Stdout,
+			Stderr:  os.Stderr,
+		}
+		for _, name := range remaining {
+			opts.Command = append(opts.Command, "/data/"+name)
+		}
+		code, err := orch.Run(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("failed to clean %s: %w", path, err)
+		}
+		if code != 0 {
+			return fmt.Errorf("failed to clean %s: rm exited with code %d", path, code)
+		}
+	}
+
+	// Remove fails on a directory that isn't empty, keeping unrelated files
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Info().Err(err).Str("path", path).Msg("Keeping the persistence path, it holds other files")
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// freeDiskSpace returns the space available to the user on the filesystem of path.
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("failed to get the free space of %s: %w", path, err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// fileOwner returns the uid owning a file.
+func fileOwner(info fs.FileInfo) (int, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(stat.Uid), true
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// freeDiskSpace is not supported on windows, where docker runs in a VM
+// with its own disk.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space is not checked on windows")
+}
+
+// fileOwner is not supported on windows, files have no uid.
+func fileOwner(info fs.FileInfo) (int, bool) {
+	return 0, false
+}
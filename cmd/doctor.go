@@ -0,0 +1,593 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/rs/zerolog/log"
+)
+
+type DoctorCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+}
+
+const (
+	minDockerVersion  = "20.10.0"
+	minComposeVersion = "2.20.0"
+	minPodmanVersion  = "4.7.0"
+
+	minFreeDisk       = 2 << 30
+	recommendedDisk   = 10 << 30
+	minMaxMapCount    = 262144
+	maxOwnershipFiles = 5
+)
+
+type checkStatus string
+
+const (
+	checkOK      checkStatus = "ok"
+	checkWarn    checkStatus = "warn"
+	checkFail    checkStatus = "fail"
+	checkSkipped checkStatus = "skipped"
+)
+
+type checkResult struct {
+	Check   string
+	Status  checkStatus
+	Message string
+	// Fix tells the user how to solve a warning or failure
+	Fix string
+}
+
+// doctorEnv is the state shared by the checks.
+type doctorEnv struct {
+	cfg     EnvironmentConfig
+	envPath string
+	// services are the long-running services of the enabled features
+	services []string
+	// running is set when containers of the environment are running
+	running bool
+	// daemon is set when the docker daemon is reachable
+	daemon bool
+}
+
+type doctorCheck func(ctx context.Context, env *doctorEnv) []checkResult
+
+// doctorChecks run in order, the docker checks first as others need the daemon.
+var doctorChecks = []doctorCheck{
+	checkDocker,
+	checkCompose,
+	checkPorts,
+	checkDisk,
+	checkPermissions,
+	checkMemory,
+	checkKernel,
+}
+
+func (c *DoctorCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	results := runDoctor(ctx, cfg, envPath)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CHECK\tSTATUS\tDETAILS")
+	for _, r := range results {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", r.Check, r.Status, r.Message)
+		if r.Fix != "" {
+			_, _ = fmt.Fprintf(w, "\t\tfix: %s\n", r.Fix)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	return doctorError(results)
+}
+
+// runDoctor runs every check against an environment.
+func runDoctor(ctx context.Context, cfg EnvironmentConfig, envPath string) []checkResult {
+	env := &doctorEnv{cfg: cfg, envPath: envPath}
+
+	if compose, err := loadComposeFile(envPath); err == nil {
+		for _, name := range compose.activeServices(getComposeProfiles(cfg)) {
+			if !isOneShotService(name) {
+				env.services = append(env.services, name)
+			}
+		}
+	}
+
+	var results []checkResult
+	for _, check := range doctorChecks {
+		results = append(results, check(ctx, env)...)
+	}
+	return results
+}
+
+// preflight runs the checks before starting an environment. Warnings are
+// logged, failures abort the start.
+func preflight(ctx context.Context, cfg EnvironmentConfig, envPath string) error {
+	results := runDoctor(ctx, cfg, envPath)
+	for _, r := range results {
+		switch r.Status {
+		case checkWarn:
+			log.Warn().Str("check", r.Check).Str("fix", r.Fix).Msg(r.Message)
+		case checkFail:
+			log.Error().Str("check", r.Check).Str("fix", r.Fix).Msg(r.Message)
+		}
+	}
+
+	if err := doctorError(results); err != nil {
+		return fmt.Errorf("%w, fix them or use --no-doctor", err)
+	}
+	return nil
+}
+
+func doctorError(results []checkResult) error {
+	failures := 0
+	for _, r := range results {
+		if r.Status == checkFail {
+			failures++
+		}
+	}
+	if failures > 0 {
+		return fmt.Errorf("preflight checks found %d problem(s)", failures)
+	}
+	return nil
+}
+
+func checkDocker(ctx context.Context, env *doctorEnv) []checkResult {
+	engine := currentContainerEngine()
+	product, versionFormat, minVersion := "Docker", "{{.ServerVersion}}", minDockerVersion
+	install := "install Docker Engine or Docker Desktop, see https://docs.docker.com/get-docker/"
+	start := "start the docker daemon and check that your user may use it, e.g. is in the docker group"
+	if engine.isPodman() {
+		product, versionFormat, minVersion = "Podman", "{{.Version.Version}}", minPodmanVersion
+		install = "install Podman, see https://podman.io/docs/installation"
+		start = "start the podman service, or the podman machine on macOS and Windows"
+	}
+
+	if _, err := exec.LookPath(engine.CLI); err != nil {
+		return []checkResult{{
+			Check:   "docker",
+			Status:  checkFail,
+			Message: engine.CLI + " is not installed",
+			Fix:     install,
+		}}
+	}
+
+	out, err := exec.CommandContext(ctx, engine.CLI, "info", "--format", versionFormat).Output()
+	version := strings.TrimSpace(string(out))
+	if err != nil || version == "" {
+		return []checkResult{{
+			Check:   "docker",
+			Status:  checkFail,
+			Message: fmt.Sprintf("the %s daemon is not reachable", engine.CLI),
+			Fix:     start,
+		}}
+	}
+	env.daemon = true
+
+	if orch, err := newOrchestrator(env.cfg, env.envPath); err == nil {
+		env.running, _ = environmentRunning(ctx, orch)
+	}
+
+	if compareVersions(version, minVersion) < 0 {
+		return []checkResult{{
+			Check:   "docker",
+			Status:  checkFail,
+			Message: fmt.Sprintf("%s %s is too old", product, version),
+			Fix:     fmt.Sprintf("upgrade %s to %s or later", product, minVersion),
+		}}
+	}
+
+	message := product + " " + version
+	if engine.Rootless {
+		message += " (rootless)"
+	}
+	return []checkResult{{Check: "docker", Status: checkOK, Message: message}}
+}
+
+// composeVersionPattern matches the version in the output of compose version,
+// e.g. Docker Compose version v2.29.1 or podman-compose version 1.0.6.
+var composeVersionPattern = regexp.MustCompile(`(\d+\.\d+\.\d+)`)
+
+func checkCompose(ctx context.Context, env *doctorEnv) []checkResult {
+	if !env.daemon {
+		return []checkResult{{Check: "compose", Status: checkSkipped, Message: "docker is not available"}}
+	}
+
+	engine := currentContainerEngine()
+	args := append(slices.Clone(engine.Compose), "version")
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		fix := "install the docker compose plugin, see https://docs.docker.com/compose/install/linux/"
+		if engine.isPodman() {
+			fix = "install docker-compose, which podman compose runs, see https://docs.podman.io/en/latest/markdown/podman-compose.1.html"
+		}
+		return []checkResult{{
+			Check:   "compose",
+			Status:  checkFail,
+			Message: strings.Join(engine.Compose, " ") + " is not installed",
+			Fix:     fix,
+		}}
+	}
+
+	if strings.Contains(string(out), "podman-compose") {
+		return []checkResult{{
+			Check:   "compose",
+			Status:  checkWarn,
+			Message: "podman compose runs podman-compose, which lacks features of Docker Compose",
+			Fix:     "install docker-compose, podman compose prefers it over podman-compose",
+		}}
+	}
+
+	version := composeVersionPattern.FindString(string(out))
+	if compareVersions(version, minComposeVersion) < 0 {
+		return []checkResult{{
+			Check:   "compose",
+			Status:  checkFail,
+			Message: fmt.Sprintf("Docker Compose %s is too old", version),
+			Fix:     fmt.Sprintf("upgrade Docker Compose to %s or later", minComposeVersion),
+		}}
+	}
+
+	return []checkResult{{Check: "compose", Status: checkOK, Message: "Docker Compose " + version}}
+}
+
+// servicePorts lists the host ports the services listen on, all services
+// using the host network.
+func servicePorts(cfg EnvironmentConfig) map[string][]int {
+	ports := listenPorts(cfg)
+	for _, e := range debugEndpoints(cfg) {
+		ports[e.Service] = append(ports[e.Service], e.Port)
+	}
+	return ports
+}
+
+// listenPorts lists the host ports of the services, without the inspector
+// ports.
+func listenPorts(cfg EnvironmentConfig) map[string][]int {
+	ports := map[string][]int{
+		"cloudserver":           {8000, 8002},
+		"s3-data":               {9991},
+		"vault":                 {8500, 8600},
+		"redis":                 {6379},
+		"scuba":                 {8100},
+		"utapi":                 {8100},
+		"zookeeper":             {2181},
+		"kafka-destination":     {},
+		"notification-receiver": {int(cfg.NotificationReceiver.Port)},
+		"clickhouse-shard-1":    {8123, 9002, 9009},
+		"clickhouse-shard-2":    {8124, 9003, 9010},
+		"fluentbit":             {2020},
+		"s3-frontend":           {int(cfg.Nginx.HTTPPort), int(cfg.Nginx.SSLPort)},
+		"metadata-s3":           metadataPorts(cfg.S3Metadata.BasePorts, cfg.S3Metadata.RaftSessions),
+		"metadata-scuba":        metadataPorts(cfg.ScubaMetadata.BasePorts, cfg.ScubaMetadata.RaftSessions),
+	}
+
+	for _, b := range cfg.Kafka.brokers() {
+		ports[b.Service] = []int{b.Port}
+		if b.Controller {
+			ports[b.Service] = append(ports[b.Service], b.ControllerPort)
+		}
+	}
+	if cfg.Kafka.KRaft() {
+		ports["kafka-destination"] = append(ports["kafka-destination"], kafkaDestinationControllerPort)
+	}
+
+	ports["kafka-destination"] = append(ports["kafka-destination"], notificationInternalPort)
+	for _, l := range cfg.Features.BucketNotifications.Listeners() {
+		ports["kafka-destination"] = append(ports["kafka-destination"], l.Port)
+	}
+
+	if migration := cfg.S3Metadata.Migration; migration != nil && migration.Deploy {
+		ports["metadata-s3"] = append(ports["metadata-s3"], metadataPorts(migration.BasePorts, cfg.S3Metadata.RaftSessions)...)
+	}
+
+	return ports
+}
+
+// validateDebugPorts checks that the inspector ports of the enabled
+// processes don't collide with each other or with the ports of the services,
+// e.g. a backbeat port offset reaching a kafka broker.
+func validateDebugPorts(cfg EnvironmentConfig) error {
+	used := map[int]string{}
+	services := listenPorts(cfg)
+	for _, service := range slices.Sorted(maps.Keys(services)) {
+		for _, port := range services[service] {
+			if port != 0 {
+				used[port] = service
+			}
+		}
+	}
+
+	for _, e := range debugEndpoints(cfg) {
+		if owner, ok := used[e.Port]; ok {
+			return fmt.Errorf("inspector port %d of %s %s is already used by %s, change %s.debug.port", e.Port, e.Service, e.Process, owner, e.Service)
+		}
+		used[e.Port] = e.Service + " " + e.Process
+	}
+	return nil
+}
+
+func metadataPorts(base MdPortConfig, raftSessions int) []int {
+	ports := []int{int(base.Bucketd)}
+	for i := 0; i < raftSessions; i++ {
+		ports = append(ports, int(base.Repd)+i, int(base.RepdAdmin)+i)
+	}
+	return ports
+}
+
+func checkPorts(ctx context.Context, env *doctorEnv) []checkResult {
+	if env.running {
+		return []checkResult{{Check: "ports", Status: checkSkipped, Message: "the environment is running"}}
+	}
+
+	all := servicePorts(env.cfg)
+	var results []checkResult
+	checked := 0
+	for _, service := range env.services {
+		for _, port := range all[service] {
+			checked++
+			if portAvailable(port) {
+				continue
+			}
+			results = append(results, checkResult{
+				Check:   "ports",
+				Status:  checkFail,
+				Message: fmt.Sprintf("port %d (%s) is already in use", port, service),
+				Fix:     fmt.Sprintf("stop the process listening on it, find it with `ss -ltnp 'sport = :%d'` or `lsof -i :%d`", port, port),
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		return []checkResult{{Check: "ports", Status: checkOK, Message: fmt.Sprintf("%d ports available", checked)}}
+	}
+	return results
+}
+
+func portAvailable(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return false
+	}
+	_ = l.Close()
+	return true
+}
+
+func checkDisk(ctx context.Context, env *doctorEnv) []checkResult {
+	free, err := freeDiskSpace(env.envPath)
+	if err != nil {
+		return []checkResult{{Check: "disk", Status: checkSkipped, Message: err.Error()}}
+	}
+
+	result := checkResult{Check: "disk", Status: checkOK, Message: formatSize(int64(free)) + " free"}
+	fix := "free up space, e.g. with `docker system prune`, or move the environment with --env-dir"
+	switch {
+	case free < minFreeDisk:
+		result.Status, result.Fix = checkFail, fix
+		result.Message = fmt.Sprintf("only %s free, at least %s needed", formatSize(int64(free)), formatSize(minFreeDisk))
+	case free < recommendedDisk:
+		result.Status, result.Fix = checkWarn, fix
+		result.Message = fmt.Sprintf("only %s free, %s recommended", formatSize(int64(free)), formatSize(recommendedDisk))
+	}
+	return []checkResult{result}
+}
+
+// checkPermissions checks that the containers, running as HOST_UID, can write
+// their logs and read their configuration.
+func checkPermissions(ctx context.Context, env *doctorEnv) []checkResult {
+	var results []checkResult
+
+	logsDir := filepath.Join(env.envPath, "logs")
+	dirs := []string{logsDir}
+	if entries, err := os.ReadDir(logsDir); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(logsDir, entry.Name()))
+			}
+		}
+	}
+	for _, dir := range dirs {
+		if err := checkWritable(dir); err != nil {
+			results = append(results, checkResult{
+				Check:   "permissions",
+				Status:  checkFail,
+				Message: fmt.Sprintf("%s is not writable: %v", dir, err),
+				Fix:     fmt.Sprintf("sudo chown -R %d:%d %s", env.cfg.HostUID, env.cfg.HostGID, logsDir),
+			})
+		}
+	}
+
+	var foreign []string
+	for _, dir := range []string{"config", "logs"} {
+		root := filepath.Join(env.envPath, dir)
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || len(foreign) >= maxOwnershipFiles {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if uid, ok := fileOwner(info); ok && uid != env.cfg.HostUID {
+				foreign = append(foreign, path)
+			}
+			return nil
+		})
+	}
+	if len(foreign) > 0 {
+		results = append(results, checkResult{
+			Check:   "permissions",
+			Status:  checkWarn,
+			Message: fmt.Sprintf("files not owned by HOST_UID %d: %s", env.cfg.HostUID, strings.Join(foreign, ", ")),
+			Fix: fmt.Sprintf("sudo chown -R %d:%d %s %s", env.cfg.HostUID, env.cfg.HostGID,
+				filepath.Join(env.envPath, "config"), logsDir),
+		})
+	}
+
+	if len(results) == 0 {
+		return []checkResult{{Check: "permissions", Status: checkOK, Message: "config and logs are accessible"}}
+	}
+	return results
+}
+
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".doctor-")
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Created by configure
+			return nil
+		}
+		return err
+	}
+	_ = f.Close()
+	return os.Remove(f.Name())
+}
+
+// serviceMemory is a rough estimate of the memory used by the services, in MiB.
+var serviceMemory = map[string]int64{
+	"cloudserver":           512,
+	"s3-data":               128,
+	"vault":                 256,
+	"metadata-s3":           512,
+	"metadata-scuba":        512,
+	"redis":                 64,
+	"scuba":                 512,
+	"backbeat":              1024,
+	"zookeeper":             256,
+	"kafka":                 1024,
+	"kafka-destination":     1024,
+	"utapi":                 256,
+	"migration-tools":       256,
+	"clickhouse-shard-1":    1024,
+	"clickhouse-shard-2":    1024,
+	"fluentbit":             64,
+	"s3-frontend":           32,
+	"notification-receiver": 32,
+}
+
+func checkMemory(ctx context.Context, env *doctorEnv) []checkResult {
+	if !env.daemon {
+		return []checkResult{{Check: "memory", Status: checkSkipped, Message: "docker is not available"}}
+	}
+
+	engine := currentContainerEngine()
+	format := "{{.MemTotal}}"
+	if engine.isPodman() {
+		format = "{{.Host.MemTotal}}"
+	}
+	out, err := exec.CommandContext(ctx, engine.CLI, "info", "--format", format).Output()
+	if err != nil {
+		return []checkResult{{Check: "memory", Status: checkSkipped, Message: "failed to get the memory of docker"}}
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return []checkResult{{Check: "memory", Status: checkSkipped, Message: "failed to parse the memory of docker"}}
+	}
+
+	var needed int64
+	brokers := env.cfg.Kafka.brokers()
+	for _, service := range env.services {
+		if slices.ContainsFunc(brokers, func(b kafkaBroker) bool { return b.Service == service }) {
+			service = "kafka"
+		}
+		if mem, ok := serviceMemory[service]; ok {
+			needed += mem << 20
+		} else {
+			needed += 128 << 20
+		}
+	}
+
+	if total < needed {
+		return []checkResult{{
+			Check:   "memory",
+			Status:  checkWarn,
+			Message: fmt.Sprintf("docker has %s of memory, the enabled features need about %s", formatSize(total), formatSize(needed)),
+			Fix:     "give more memory to docker (e.g. in the Docker Desktop resources) or disable features in values.yaml",
+		}}
+	}
+
+	return []checkResult{{
+		Check:   "memory",
+		Status:  checkOK,
+		Message: fmt.Sprintf("%s available, about %s needed", formatSize(total), formatSize(needed)),
+	}}
+}
+
+func checkKernel(ctx context.Context, env *doctorEnv) []checkResult {
+	data, err := os.ReadFile("/proc/sys/vm/max_map_count")
+	if err != nil {
+		return []checkResult{{Check: "kernel", Status: checkSkipped, Message: "vm.max_map_count is not available"}}
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return []checkResult{{Check: "kernel", Status: checkSkipped, Message: "failed to parse vm.max_map_count"}}
+	}
+
+	if count < minMaxMapCount {
+		return []checkResult{{
+			Check:   "kernel",
+			Status:  checkWarn,
+			Message: fmt.Sprintf("vm.max_map_count is %d, %d recommended", count, minMaxMapCount),
+			Fix:     fmt.Sprintf("sudo sysctl -w vm.max_map_count=%d, add it to /etc/sysctl.conf to keep it", minMaxMapCount),
+		}}
+	}
+
+	return []checkResult{{Check: "kernel", Status: checkOK, Message: fmt.Sprintf("vm.max_map_count is %d", count)}}
+}
+
+// compareVersions compares dotted versions such as 27.1.1 or v2.29.1-desktop.1,
+// ignoring any prefix and suffix.
+func compareVersions(a, b string) int {
+	parse := func(v string) []int {
+		v = strings.TrimPrefix(v, "v")
+		if i := strings.IndexAny(v, "-+ "); i >= 0 {
+			v = v[:i]
+		}
+		var parts []int
+		for _, p := range strings.Split(v, ".") {
+			n, _ := strconv.Atoi(p)
+			parts = append(parts, n)
+		}
+		return parts
+	}
+
+	pa, pb := parse(a), parse(b)
+	for len(pa) < len(pb) {
+		pa = append(pa, 0)
+	}
+	for len(pb) < len(pa) {
+		pb = append(pb, 0)
+	}
+	return slices.Compare(pa, pb)
+}
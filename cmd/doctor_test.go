@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateDebugPorts(t *testing.T) {
+	_, envPath := newTestEnv(t)
+	defaults, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		update       func(cfg *EnvironmentConfig)
+		errorMessage string
+	}{
+		{name: "debug disabled", update: func(cfg *EnvironmentConfig) {}},
+		{
+			name: "default ports",
+			update: func(cfg *EnvironmentConfig) {
+				cfg.Cloudserver.Debug = DebugConfig{Enabled: true, Port: 9229}
+				cfg.Vault.Debug = DebugConfig{Enabled: true, Port: 9230}
+				cfg.Backbeat.Debug = DebugConfig{Enabled: true, Port: 9240}
+			},
+		},
+		{
+			name:         "service port",
+			update:       func(cfg *EnvironmentConfig) { cfg.Cloudserver.Debug = DebugConfig{Enabled: true, Port: 8000} },
+			errorMessage: "inspector port 8000 of cloudserver cloudserver is already used by cloudserver",
+		},
+		{
+			name: "other inspector",
+			update: func(cfg *EnvironmentConfig) {
+				cfg.Cloudserver.Debug = DebugConfig{Enabled: true, Port: 9229}
+				cfg.Vault.Debug = DebugConfig{Enabled: true, Port: 9229}
+			},
+			errorMessage: "already used by cloudserver cloudserver",
+		},
+		{
+			name: "backbeat offset reaching a broker",
+			update: func(cfg *EnvironmentConfig) {
+				cfg.Kafka.Brokers = 3
+				cfg.Backbeat.Debug = DebugConfig{Enabled: true, Port: 9290}
+			},
+			errorMessage: "inspector port 9292 of backbeat lifecycle-object-processor is already used by kafka-3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaults
+			cfg.Features.Lifecycle.Enabled = true
+			tt.update(&cfg)
+			if err := cfg.Backbeat.resolveProcesses(cfg.Features); err != nil {
+				t.Fatal(err)
+			}
+
+			err := validateDebugPorts(cfg)
+			if tt.errorMessage == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorMessage) {
+				t.Fatalf("expected an error containing %q, got %v", tt.errorMessage, err)
+			}
+		})
+	}
+}
@@ -5,10 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
-	"strings"
 	"syscall"
 )
 
@@ -39,28 +37,27 @@ func (c *DownCmd) Run() error {
 		return err
 	}
 
-	args := []string{"down", "--timeout", fmt.Sprintf("%d", c.Timeout)}
-	if c.Volumes {
-		args = append(args, "--volumes")
-	}
-
-	dockerComposeCmd := buildDockerComposeCommand(cfg, args...)
-
-	fmt.Println(strings.Join(dockerComposeCmd, " "))
-
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, dockerComposeCmd[0], dockerComposeCmd[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = envPath
-	if err := cmd.Run(); err != nil {
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	if err := orch.Down(ctx, DownOptions{Timeout: c.Timeout, Volumes: c.Volumes}); err != nil {
 		if errors.Is(ctx.Err(), context.Canceled) {
 			return nil
 		}
 		return err
 	}
 
+	// New kafka volumes are formatted in the mode of the next up
+	if c.Volumes {
+		if err := os.Remove(filepath.Join(envPath, kafkaModeFile)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", kafkaModeFile, err)
+		}
+	}
+
 	return nil
 }
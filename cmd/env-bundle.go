@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type EnvCmd struct {
+	Export EnvExportCmd `cmd:"" help:"Export an environment as a portable bundle."`
+	Import EnvImportCmd `cmd:"" help:"Recreate an environment from a bundle."`
+}
+
+type EnvExportCmd struct {
+	EnvDir   string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name     string `help:"Name of the environment to export. default: 'default'" short:"n"`
+	Output   string `help:"Path of the bundle. default: '<env-dir>/<name>-bundle.tar.gz'" short:"o"`
+	WithData bool   `help:"Include a snapshot of the environment's data. Stops the stack while archiving."`
+}
+
+type EnvImportCmd struct {
+	EnvDir    string `help:"Directory to create the environment in. default: './env'" short:"d"`
+	Name      string `help:"Name of the environment to create. default: the exported environment's name" short:"n"`
+	Overwrite bool   `help:"Overwrite the environment if it already exists." short:"o"`
+	NoPull    bool   `help:"Don't pull missing images, only verify the digests of local images."`
+	Force     bool   `help:"Replace the data of a persistence path outside of the environment."`
+	Bundle    string `arg:"" help:"Path of the bundle." type:"existingfile"`
+}
+
+const bundleManifestFile = "bundle.json"
+
+// bundleManifest describes an environment bundle.
+type bundleManifest struct {
+	Environment string            `json:"environment"`
+	Created     time.Time         `json:"created"`
+	Images      map[string]string `json:"images"`
+	Templates   bool              `json:"templates"`
+	Snapshot    bool              `json:"snapshot"`
+}
+
+func (c *EnvExportCmd) Run() (err error) {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	for name, dev := range map[string]DevConfig{"cloudserver": cfg.Cloudserver.Dev, "vault": cfg.Vault.Dev, "backbeat": cfg.Backbeat.Dev} {
+		if dev.Enabled() {
+			log.Warn().Str("component", name).Str("source", dev.Source).Msg("Dev source checkouts are not included in the bundle")
+		}
+	}
+
+	output := c.Output
+	if output == "" {
+		output = filepath.Join(rc.EnvDir, rc.EnvName+"-bundle.tar.gz")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	manifest := bundleManifest{
+		Environment: rc.EnvName,
+		Created:     time.Now().UTC(),
+		Images:      map[string]string{},
+		Templates:   CLI.TemplatesDir != "",
+		Snapshot:    c.WithData,
+	}
+
+	for _, image := range cfg.images() {
+		digest, err := imageDigest(ctx, image)
+		if err != nil {
+			log.Warn().Err(err).Str("image", image).Msg("Image is not available locally, its digest is not locked")
+			continue
+		}
+		manifest.Images[image] = digest
+	}
+
+	bundle, err := newTarGzWriter(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := bundle.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write bundle: %w", closeErr)
+		}
+	}()
+
+	for _, name := range []string{"values.yaml", "docker-compose.yaml", imageLockFile} {
+		data, err := os.ReadFile(filepath.Join(envPath, name))
+		if name == imageLockFile && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := bundle.AddFile(name, data); err != nil {
+			return err
+		}
+	}
+
+	if CLI.TemplatesDir != "" {
+		if err := bundle.AddDir(CLI.TemplatesDir, "templates", nil); err != nil {
+			return fmt.Errorf("failed to archive templates: %w", err)
+		}
+	}
+
+	if c.WithData {
+		if err := addSnapshotToBundle(ctx, cfg, rc.EnvName, envPath, bundle); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+	if err := bundle.AddFile(bundleManifestFile, append(data, '\n')); err != nil {
+		return err
+	}
+
+	log.Info().Str("output", output).Int("images", len(manifest.Images)).Msg("Environment exported")
+	return nil
+}
+
+// addSnapshotToBundle snapshots the environment and adds the snapshot to the
+// bundle under snapshot/. A running stack is stopped meanwhile.
+func addSnapshotToBundle(ctx context.Context, cfg EnvironmentConfig, envName, envPath string, bundle *tarGzWriter) error {
+	tmpDir, err := os.MkdirTemp("", "workbench-export-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	running, err := environmentRunning(ctx, orch)
+	if err != nil {
+		return err
+	}
+
+	if err := orch.Stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop environment: %w", err)
+	}
+
+	_, snapErr := createSnapshot(ctx, cfg, envName, envPath, "export", tmpDir)
+
+	if running {
+		if err := orch.Start(ctx); err != nil {
+			return errors.Join(snapErr, fmt.Errorf("failed to restart environment: %w", err))
+		}
+	}
+
+	if snapErr != nil {
+		return fmt.Errorf("failed to snapshot environment: %w", snapErr)
+	}
+
+	return bundle.AddDir(tmpDir, "snapshot", nil)
+}
+
+func (c *EnvImportCmd) Run() error {
+	// Extract next to the environments so the templates can be moved in place
+	envDir := RuntimeConfigFromFlags(c.EnvDir, "").EnvDir
+	if err := os.MkdirAll(envDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", envDir, err)
+	}
+
+	tmpDir, err := os.MkdirTemp(envDir, ".import-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := extractTarGz(c.Bundle, tmpDir); err != nil {
+		return fmt.Errorf("failed to extract bundle: %w", err)
+	}
+
+	var manifest bundleManifest
+	data, err := os.ReadFile(filepath.Join(tmpDir, bundleManifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to read bundle manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("failed to parse bundle manifest: %w", err)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = manifest.Environment
+	}
+	// The name of a bundle is untrusted, it must not escape the env dir
+	if name != "" && !validEnvName(name) {
+		return fmt.Errorf("invalid environment name %q, use --name to choose another one", name)
+	}
+	rc := RuntimeConfigFromFlags(envDir, name)
+
+	if _, err := os.Stat(filepath.Join(rc.EnvDir, rc.EnvName)); err == nil && !c.Overwrite {
+		return fmt.Errorf("environment %s already exists, use --overwrite to replace it", rc.EnvName)
+	}
+
+	envPath, err := createEnv(rc.EnvDir, rc.EnvName, true,
+		filepath.Join(tmpDir, "values.yaml"),
+		filepath.Join(tmpDir, "docker-compose.yaml"))
+	if err != nil {
+		return fmt.Errorf("failed to create environment: %w", err)
+	}
+
+	// Pin the images of the environment as they were pinned when exported
+	lockPath := filepath.Join(envPath, imageLockFile)
+	if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", imageLockFile, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, imageLockFile)); err == nil {
+		if err := copyFile(filepath.Join(tmpDir, imageLockFile), lockPath); err != nil {
+			return fmt.Errorf("failed to import %s: %w", imageLockFile, err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if manifest.Templates {
+		templatesDir := filepath.Join(envPath, "templates")
+		if err := os.RemoveAll(templatesDir); err != nil {
+			return fmt.Errorf("failed to remove templates: %w", err)
+		}
+		if err := os.Rename(filepath.Join(tmpDir, "templates"), templatesDir); err != nil {
+			return fmt.Errorf("failed to import templates: %w", err)
+		}
+		log.Warn().Str("templates", templatesDir).Msg("The bundle overrides templates, pass them with --templates-dir")
+	}
+
+	verifyImageDigests(ctx, manifest.Images, !c.NoPull)
+
+	if manifest.Snapshot {
+		if _, err := restoreSnapshot(ctx, filepath.Join(tmpDir, "snapshot"), envPath, c.Force); err != nil {
+			return fmt.Errorf("failed to restore data: %w", err)
+		}
+	}
+
+	log.Info().Str("environment", rc.EnvName).Msg("Environment imported")
+	return nil
+}
+
+// verifyImageDigests compares local images with the digests locked in a
+// bundle, pulling missing images first when pull is set. Mismatches are
+// reported as warnings as the environment may still work.
+func verifyImageDigests(ctx context.Context, images map[string]string, pull bool) {
+	for _, image := range sortedKeys(images) {
+		expected := images[image]
+
+		if !imageExists(ctx, image) {
+			if !pull {
+				log.Warn().Str("image", image).Msg("Image is missing locally")
+				continue
+			}
+			if err := pullImage(ctx, image); err != nil {
+				log.Warn().Err(err).Str("image", image).Msg("Failed to pull image")
+				continue
+			}
+		}
+
+		actual, err := imageDigest(ctx, image)
+		if err != nil {
+			log.Warn().Err(err).Str("image", image).Msg("Failed to verify image digest")
+			continue
+		}
+		if actual != expected {
+			log.Warn().
+				Str("image", image).
+				Str("expected", expected).
+				Str("actual", actual).
+				Msg("Image digest differs from the exported environment")
+		}
+	}
+}
+
+// validEnvName reports whether name is a single path element below the env
+// dir.
+func validEnvName(name string) bool {
+	return filepath.IsLocal(name) && filepath.Base(name) == name && name != "."
+}
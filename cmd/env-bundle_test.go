@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestValidEnvName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{name: "default", valid: true},
+		{name: "ci-run_42", valid: true},
+		{name: "..hidden", valid: true},
+		{name: ""},
+		{name: "."},
+		{name: ".."},
+		{name: "../escaped"},
+		{name: "../../etc"},
+		{name: "nested/env"},
+		{name: "/abs/path"},
+		{name: "env/.."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validEnvName(tt.name); got != tt.valid {
+				t.Errorf("expected %t, got %t", tt.valid, got)
+			}
+		})
+	}
+}
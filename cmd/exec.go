@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type ExecCmd struct {
+	EnvDir  string   `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name    string   `help:"Name of the environment. default: 'default'" short:"n"`
+	User    string   `help:"Run the command as this user." short:"u"`
+	Env     []string `help:"Set environment variables (KEY=VALUE). Can be repeated." short:"e" sep:"none"`
+	Service string   `arg:"" help:"Service or container name (e.g. cloudserver, workbench-s3, s3)."`
+	Command []string `arg:"" passthrough:"" help:"Command to run in the container, after '--'."`
+}
+
+func (c *ExecCmd) Run() error {
+	if len(c.Command) > 0 && c.Command[0] == "--" {
+		c.Command = c.Command[1:]
+	}
+	if len(c.Command) == 0 {
+		return errors.New("no command given, use 'exec <service> -- <command>'")
+	}
+
+	target, err := loadExecTarget(c.EnvDir, c.Name, c.Service)
+	if err != nil {
+		return err
+	}
+
+	return target.exec(c.User, c.Env, c.Command)
+}
+
+type ShellCmd struct {
+	EnvDir  string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name    string `help:"Name of the environment. default: 'default'" short:"n"`
+	User    string `help:"Run the shell as this user." short:"u"`
+	Service string `arg:"" help:"Service or container name (e.g. vault, kafka, cloudserver)."`
+}
+
+func (c *ShellCmd) Run() error {
+	target, err := loadExecTarget(c.EnvDir, c.Name, c.Service)
+	if err != nil {
+		return err
+	}
+
+	rc := []string{
+		fmt.Sprintf("export AWS_ACCESS_KEY_ID=%s", testAccountAccessKey),
+		fmt.Sprintf("export AWS_SECRET_ACCESS_KEY=%s", testAccountSecretKey),
+		fmt.Sprintf("export AWS_DEFAULT_REGION=%s", defaultRegion),
+		"export AWS_ENDPOINT_URL=http://127.0.0.1:8000",
+	}
+
+	if profile, ok := shellProfiles[target.service]; ok {
+		lines, err := profile(target.cfg, target.envPath)
+		if err != nil {
+			return fmt.Errorf("failed to prepare %s shell: %w", target.service, err)
+		}
+		rc = append(rc, lines...)
+	}
+
+	env := []string{"WORKBENCH_RC=" + strings.Join(rc, "\n")}
+	return target.exec(c.User, env, []string{"sh", "-c", shellLauncher})
+}
+
+// shellLauncher writes WORKBENCH_RC to a file sourced by the interactive
+// shell, preferring bash when the image has it.
+const shellLauncher = `rc="$(mktemp)"
+printf '%s\n' "$WORKBENCH_RC" > "$rc"
+unset WORKBENCH_RC
+if command -v bash >/dev/null 2>&1; then
+	exec bash --rcfile "$rc" -i
+fi
+ENV="$rc" exec sh -i`
+
+// shellProfiles return shell rc lines preconfiguring the tooling of a service.
+var shellProfiles = map[string]func(cfg EnvironmentConfig, envPath string) ([]string, error){
+	"vault":             vaultShellProfile,
+	"kafka":             kafkaShellProfile("127.0.0.1:9092", ""),
+	"kafka-destination": kafkaDestinationShellProfile,
+}
+
+func vaultShellProfile(cfg EnvironmentConfig, envPath string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(envPath, "config", "vault", "management-creds.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read management credentials: %w", err)
+	}
+
+	var creds struct {
+		AccessKey string `json:"accessKey"`
+		SecretKey string `json:"secretKey"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse management credentials: %w", err)
+	}
+
+	return []string{
+		`export PATH="$PWD/node_modules/vaultclient/bin:$PATH"`,
+		fmt.Sprintf("export ADMIN_ACCESS_KEY_ID=%s", creds.AccessKey),
+		fmt.Sprintf("export ADMIN_SECRET_ACCESS_KEY=%s", creds.SecretKey),
+		"alias vaultclient='vaultclient --host 127.0.0.1 --port 8600'",
+		"echo 'vaultclient is configured with the management credentials, e.g. vaultclient list-accounts'",
+	}, nil
+}
+
+// kafkaDestinationShellProfile connects the kafka tooling to the listener of
+// destinationAuth, with the client settings of config.properties when it
+// needs any.
+func kafkaDestinationShellProfile(cfg EnvironmentConfig, envPath string) ([]string, error) {
+	listener := cfg.Features.BucketNotifications.Listeners()[0]
+	commandConfig := ""
+	if listener.Protocol != "PLAINTEXT" {
+		commandConfig = notificationsConfig
+	}
+	bootstrap := net.JoinHostPort("127.0.0.1", strconv.Itoa(listener.Port))
+	return kafkaShellProfile(bootstrap, commandConfig)(cfg, envPath)
+}
+
+func kafkaShellProfile(bootstrap, commandConfig string) func(cfg EnvironmentConfig, envPath string) ([]string, error) {
+	return func(cfg EnvironmentConfig, envPath string) ([]string, error) {
+		topics := "kafka-topics.sh --bootstrap-server $KAFKA_BOOTSTRAP_SERVER"
+		groups := "kafka-consumer-groups.sh --bootstrap-server $KAFKA_BOOTSTRAP_SERVER"
+		consume := "kafka-console-consumer.sh --bootstrap-server $KAFKA_BOOTSTRAP_SERVER"
+		produce := "kafka-console-producer.sh --bootstrap-server $KAFKA_BOOTSTRAP_SERVER"
+		if commandConfig != "" {
+			topics += " --command-config " + commandConfig
+			groups += " --command-config " + commandConfig
+			consume += " --consumer.config " + commandConfig
+			produce += " --producer.config " + commandConfig
+		}
+
+		return []string{
+			`export PATH="/opt/kafka/bin:$PATH"`,
+			fmt.Sprintf("export KAFKA_BOOTSTRAP_SERVER=%s", bootstrap),
+			fmt.Sprintf("alias topics='%s'", topics),
+			fmt.Sprintf("alias consumer-groups='%s'", groups),
+			fmt.Sprintf("alias consume='%s'", consume),
+			fmt.Sprintf("alias produce='%s'", produce),
+			"echo \"Connected to $KAFKA_BOOTSTRAP_SERVER, aliases: topics, consumer-groups, consume, produce\"",
+		}, nil
+	}
+}
+
+// execTarget is a service of an environment resolved through its compose file.
+type execTarget struct {
+	cfg     EnvironmentConfig
+	envPath string
+	service string
+}
+
+func loadExecTarget(envDir, name, service string) (execTarget, error) {
+	rc := RuntimeConfigFromFlags(envDir, name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return execTarget{}, fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return execTarget{}, err
+	}
+
+	compose, err := loadComposeFile(envPath)
+	if err != nil {
+		return execTarget{}, err
+	}
+
+	resolved, err := compose.resolveService(service)
+	if err != nil {
+		return execTarget{}, err
+	}
+
+	return execTarget{cfg: cfg, envPath: envPath, service: resolved}, nil
+}
+
+func (t execTarget) exec(user string, env, command []string) error {
+	orch, err := newOrchestrator(t.cfg, t.envPath)
+	if err != nil {
+		return err
+	}
+
+	// Signals are forwarded to the container by docker, keep workbench alive
+	// until the command exits.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	opts := ExecOptions{
+		Service: t.service,
+		User:    user,
+		Env:     env,
+		Command: command,
+		TTY:     isTerminal(os.Stdin),
+		Stdin:   os.Stdin,
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	}
+	code, err := orch.Exec(context.Background(), opts)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return exitCodeError{code: code}
+	}
+	return nil
+}
+
+// exitCodeError is returned by commands whose container command failed, main
+// exits with its code without printing an error.
+type exitCodeError struct {
+	code int
+}
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
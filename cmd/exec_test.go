@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestExecCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t)
+	orch.ExecOutput = "config.json\n"
+
+	cmd := ExecCmd{EnvDir: envDir, Name: DefaultEnvName, Service: "s3", Command: []string{"--", "ls", "/conf"}}
+	out, err := captureStdout(t, cmd.Run)
+	if err != nil {
+		t.Fatalf("exec failed: %v", err)
+	}
+
+	assertCalls(t, orch, "exec cloudserver ls /conf")
+	if out != orch.ExecOutput {
+		t.Errorf("expected the output of the command, got %q", out)
+	}
+}
+
+func TestExecCmdExitCode(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t)
+	orch.ExitCode = 3
+
+	cmd := ExecCmd{EnvDir: envDir, Name: DefaultEnvName, Service: "vault", Command: []string{"--", "false"}}
+	_, err := captureStdout(t, cmd.Run)
+	var exitErr exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+}
+
+func TestExecCmdErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		command []string
+	}{
+		{name: "no command", service: "cloudserver", command: []string{"--"}},
+		{name: "unknown service", service: "warp-drive", command: []string{"--", "ls"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := useFakeOrchestrator(t)
+			envDir, _ := newTestEnv(t)
+
+			cmd := ExecCmd{EnvDir: envDir, Name: DefaultEnvName, Service: tt.service, Command: tt.command}
+			if err := cmd.Run(); err == nil {
+				t.Fatal("expected exec to fail")
+			}
+			assertCalls(t, orch)
+		})
+	}
+}
+
+func TestKafkaDestinationShellProfile(t *testing.T) {
+	tests := []struct {
+		name      string
+		auth      NotificationAuthConfig
+		bootstrap string
+		config    bool
+	}{
+		{name: "none", auth: NotificationAuthConfig{Type: notificationAuthNone}, bootstrap: "127.0.0.1:9094"},
+		{name: "basic", auth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "u", Password: "p"},
+			bootstrap: "127.0.0.1:9094", config: true},
+		{name: "ssl", auth: NotificationAuthConfig{Type: notificationAuthSSL}, bootstrap: "127.0.0.1:9094", config: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg EnvironmentConfig
+			cfg.Features.BucketNotifications.Enabled = true
+			cfg.Features.BucketNotifications.DestinationAuth = tt.auth
+			if err := cfg.Features.BucketNotifications.resolve(8080); err != nil {
+				t.Fatal(err)
+			}
+
+			lines, err := kafkaDestinationShellProfile(cfg, t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Contains(lines, "export KAFKA_BOOTSTRAP_SERVER="+tt.bootstrap) {
+				t.Errorf("expected bootstrap %s, got %q", tt.bootstrap, lines)
+			}
+			usesConfig := slices.ContainsFunc(lines, func(l string) bool { return strings.Contains(l, notificationsConfig) })
+			if usesConfig != tt.config {
+				t.Errorf("expected client config %t, got %q", tt.config, lines)
+			}
+		})
+	}
+}
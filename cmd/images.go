@@ -0,0 +1,236 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// imageDigest returns the repository digest (repo@sha256:...) of a local
+// image. Images that were never pushed or pulled have no repository digest,
+// their image ID is returned instead.
+func imageDigest(ctx context.Context, ref string) (string, error) {
+	out, err := commandOutput(ctx, "", currentContainerEngine().CLI, "image", "inspect", "--format", "{{json .RepoDigests}} {{.Id}}", ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+
+	digestsJSON, id, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+
+	var digests []string
+	if err := json.Unmarshal([]byte(digestsJSON), &digests); err != nil {
+		return "", fmt.Errorf("failed to parse digests of %s: %w", ref, err)
+	}
+
+	repo := imageRepository(ref)
+	for _, digest := range digests {
+		if name, _, _ := strings.Cut(digest, "@"); normalizeImageName(name) == normalizeImageName(repo) {
+			return digest, nil
+		}
+	}
+	if len(digests) > 0 {
+		return digests[0], nil
+	}
+	return id, nil
+}
+
+// imageRepository strips the tag and digest from an image reference.
+func imageRepository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	// A colon after the last slash separates the tag, before it a registry port
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// normalizeImageName expands the implicit docker.io registry and library
+// namespace of an image name, e.g. redis becomes docker.io/library/redis.
+func normalizeImageName(name string) string {
+	first, rest, found := strings.Cut(name, "/")
+	if !found {
+		return "docker.io/library/" + name
+	}
+	if !strings.ContainsAny(first, ".:") && first != "localhost" {
+		return "docker.io/" + name
+	}
+	if first == "index.docker.io" {
+		name = "docker.io/" + rest
+		first, rest, _ = strings.Cut(name, "/")
+	}
+	if first == "docker.io" && !strings.Contains(rest, "/") {
+		return "docker.io/library/" + rest
+	}
+	return name
+}
+
+// imageExists reports whether an image is present locally.
+func imageExists(ctx context.Context, ref string) bool {
+	_, err := commandOutput(ctx, "", currentContainerEngine().CLI, "image", "inspect", "--format", "{{.Id}}", ref)
+	return err == nil
+}
+
+// pullImage pulls an image, showing the progress of docker pull.
+func pullImage(ctx context.Context, ref string) error {
+	log.Info().Str("image", ref).Msg("Pulling image")
+	cmd := exec.CommandContext(ctx, currentContainerEngine().CLI, "pull", ref)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	return nil
+}
+
+type ImagesCmd struct {
+	Save ImagesSaveCmd `cmd:"" help:"Save the images needed by an environment to an archive."`
+	Load ImagesLoadCmd `cmd:"" help:"Load images from an archive created by images save."`
+}
+
+type ImagesSaveCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+	Output string `help:"Path of the archive, compressed when ending in .gz. default: '<env-dir>/<name>-images.tar.gz'" short:"o"`
+	NoPull bool   `help:"Don't pull or build missing images, fail instead."`
+}
+
+type ImagesLoadCmd struct {
+	Archive string `arg:"" help:"Path of the archive." type:"existingfile"`
+}
+
+func (c *ImagesSaveCmd) Run() (err error) {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	// The images depend on the enabled features and the lock
+	if err := configureEnv(cfg, envPath); err != nil {
+		return fmt.Errorf("failed to configure environment: %w", err)
+	}
+
+	output := c.Output
+	if output == "" {
+		output = filepath.Join(rc.EnvDir, rc.EnvName+"-images.tar.gz")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if !c.NoPull {
+		if err := runDockerCompose(ctx, cfg, envPath, "pull", "--ignore-buildable"); err != nil {
+			return fmt.Errorf("failed to pull images: %w", err)
+		}
+		if err := runDockerCompose(ctx, cfg, envPath, "build"); err != nil {
+			return fmt.Errorf("failed to build images: %w", err)
+		}
+	}
+
+	images, err := composeImages(ctx, cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	if missing := missingImages(ctx, images); len(missing) > 0 {
+		return fmt.Errorf("missing images: %s", strings.Join(missing, ", "))
+	}
+
+	log.Info().Strs("images", images).Msg("Saving images")
+
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+	defer func() {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write %s: %w", output, closeErr)
+		}
+	}()
+
+	var w io.Writer = out
+	if strings.HasSuffix(output, ".gz") {
+		gz := gzip.NewWriter(out)
+		defer func() {
+			if closeErr := gz.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to compress %s: %w", output, closeErr)
+			}
+		}()
+		w = gz
+	}
+
+	engine := currentContainerEngine()
+	args := []string{"save"}
+	if engine.isPodman() {
+		// podman saves a single image unless asked otherwise
+		args = append(args, "--multi-image-archive")
+	}
+	cmd := exec.CommandContext(ctx, engine.CLI, append(args, images...)...)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to save images: %w", err)
+	}
+
+	log.Info().Str("output", output).Int("images", len(images)).Msg("Images saved")
+	return nil
+}
+
+func (c *ImagesLoadCmd) Run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// docker load detects compressed archives
+	cmd := exec.CommandContext(ctx, currentContainerEngine().CLI, "load", "--input", c.Archive)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to load images: %w", err)
+	}
+	return nil
+}
+
+// composeImages lists the images of the services of the enabled features,
+// including the images built by compose.
+func composeImages(ctx context.Context, cfg EnvironmentConfig, envPath string) ([]string, error) {
+	out, err := commandOutput(ctx, envPath, buildDockerComposeCommand(cfg, "config", "--images")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var images []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if image := strings.TrimSpace(line); image != "" && !slices.Contains(images, image) {
+			images = append(images, image)
+		}
+	}
+	slices.Sort(images)
+	return images, nil
+}
+
+// missingImages returns the images that are not present locally.
+func missingImages(ctx context.Context, images []string) []string {
+	var missing []string
+	for _, image := range images {
+		if !imageExists(ctx, image) {
+			missing = append(missing, image)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestNormalizeImageName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "redis:8", expected: "docker.io/library/redis:8"},
+		{name: "bitnami/kafka:3.4.0", expected: "docker.io/bitnami/kafka:3.4.0"},
+		{name: "docker.io/redis:8", expected: "docker.io/library/redis:8"},
+		{name: "index.docker.io/bitnami/kafka", expected: "docker.io/bitnami/kafka"},
+		{name: "ghcr.io/scality/vault:8", expected: "ghcr.io/scality/vault:8"},
+		{name: "localhost/workbench:dev", expected: "localhost/workbench:dev"},
+		{name: "registry:5000/app", expected: "registry:5000/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeImageName(tt.name); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestRegistryRewrite(t *testing.T) {
+	registry := RegistryConfig{Rewrite: []RegistryRewriteRule{
+		{From: "ghcr.io/", To: "mirror.local/ghcr/"},
+		{From: "ghcr.io/scality/vault", To: "mirror.local/vault-dev"},
+		{From: "docker.io/library/", To: "mirror.local/hub/"},
+	}}
+
+	tests := []struct {
+		ref      string
+		expected string
+	}{
+		{ref: "", expected: ""},
+		{ref: "ghcr.io/scality/cloudserver:9.0.1", expected: "mirror.local/ghcr/scality/cloudserver:9.0.1"},
+		{ref: "ghcr.io/scality/vault:8.0.0", expected: "mirror.local/vault-dev:8.0.0"},
+		{ref: "redis:8", expected: "mirror.local/hub/redis:8"},
+		{ref: "bitnami/kafka:3.4.0", expected: "bitnami/kafka:3.4.0"},
+		{ref: "quay.io/other/image@sha256:0123", expected: "quay.io/other/image@sha256:0123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if got := registry.rewrite(tt.ref); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	if got := (RegistryConfig{}).rewrite("redis:8"); got != "redis:8" {
+		t.Errorf("expected no rewrite without rules, got %s", got)
+	}
+	if err := (RegistryConfig{Rewrite: []RegistryRewriteRule{{To: "mirror.local/"}}}).validate(); err == nil {
+		t.Error("expected a rule without from prefix to be rejected")
+	}
+}
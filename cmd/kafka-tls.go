@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// kafkaTLSDir holds the certificates of the ssl listeners of
+// kafka-destination, below the kafka config directory. Each certificate comes
+// as <name>.crt, <name>.key and <name>.pem, the key and certificate in one
+// file for the PEM keystores of kafka.
+const kafkaTLSDir = "tls"
+
+// kafkaCertificate is a certificate signed by the kafka CA.
+type kafkaCertificate struct {
+	name       string
+	commonName string
+	// server certificates are valid for the broker listeners
+	server bool
+}
+
+// generateKafkaTLS creates a CA and the certificates it signs for mutual TLS
+// with kafka-destination: the broker, the client of destinationAuth used by
+// the kafka tooling and one per destination using ssl. Like
+// generateTLSCertificate, existing certificates are kept, unless the CA is
+// recreated.
+func generateKafkaTLS(cfg EnvironmentConfig, dir string) error {
+	notifications := cfg.Features.BucketNotifications
+	if !notifications.usesSSL() {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	ca, caKey, err := loadOrCreateKafkaCA(dir)
+	if err != nil {
+		return err
+	}
+
+	clientName := notifications.DestinationAuth.Username
+	if clientName == "" {
+		clientName = "workbench"
+	}
+	certs := []kafkaCertificate{
+		{"broker", "kafka-destination", true},
+		{"client", clientName, false},
+	}
+	for _, d := range notifications.Destinations {
+		if d.Auth.Type != notificationAuthSSL {
+			continue
+		}
+		commonName := d.Auth.Username
+		if commonName == "" {
+			commonName = d.Resource
+		}
+		certs = append(certs, kafkaCertificate{"destination-" + d.Resource, commonName, false})
+	}
+
+	for _, c := range certs {
+		if _, err := os.Stat(filepath.Join(dir, c.name+".pem")); err == nil {
+			continue
+		}
+		if err := issueKafkaCertificate(dir, c, ca, caKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadOrCreateKafkaCA returns the CA of dir, creating it when missing. The
+// certificates of a previous CA are removed.
+func loadOrCreateKafkaCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, certErr := os.ReadFile(filepath.Join(dir, "ca.crt"))
+	keyPEM, keyErr := os.ReadFile(filepath.Join(dir, "ca.key"))
+	if certErr == nil && keyErr == nil {
+		ca, key, err := parseKafkaCA(certPEM, keyPEM)
+		if err == nil {
+			return ca, key, nil
+		}
+		log.Warn().Err(err).Str("dir", dir).Msg("Invalid kafka CA, creating a new one")
+	}
+
+	stale, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	template, err := certificateTemplate("Scality Workbench Kafka CA")
+	if err != nil {
+		return nil, nil, err
+	}
+	template.IsCA = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	if err := writeKafkaCertificate(dir, "ca", der, key); err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	log.Info().Str("dir", dir).Msg("Generated kafka CA")
+	return ca, key, nil
+}
+
+func parseKafkaCA(certPEM, keyPEM []byte) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return nil, nil, errors.New("no PEM data")
+	}
+
+	ca, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("CA key is not an ECDSA key")
+	}
+	return ca, key, nil
+}
+
+// issueKafkaCertificate creates a certificate signed by the CA.
+func issueKafkaCertificate(dir string, cert kafkaCertificate, ca *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	name := cert.name
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s key: %w", name, err)
+	}
+	template, err := certificateTemplate(cert.commonName)
+	if err != nil {
+		return err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	if cert.server {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsageServerAuth)
+		template.DNSNames = []string{"localhost"}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create %s certificate: %w", name, err)
+	}
+	if err := writeKafkaCertificate(dir, name, der, key); err != nil {
+		return err
+	}
+
+	log.Debug().Str("name", name).Str("cn", cert.commonName).Msg("Generated kafka certificate")
+	return nil
+}
+
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Scality Workbench"},
+			CommonName:   commonName,
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		BasicConstraintsValid: true,
+	}, nil
+}
+
+// writeKafkaCertificate writes the certificate, its key and both in a .pem
+// file. Keys are PKCS#8, the only format of kafka PEM keystores, and world
+// readable for the containers not running as the host user.
+func writeKafkaCertificate(dir, name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s key: %w", name, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	files := map[string][]byte{
+		name + ".crt": certPEM,
+		name + ".key": keyPEM,
+		name + ".pem": append(append([]byte{}, keyPEM...), certPEM...),
+	}
+	for file, data := range files {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	}
+	return nil
+}
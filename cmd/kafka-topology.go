@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kafkaModeZookeeper = "zookeeper"
+	kafkaModeKRaft     = "kraft"
+
+	// kafkaClusterID is the ID of the KRaft clusters. It needs not be unique
+	// as each environment has its own volumes.
+	kafkaClusterID = "d29ya2JlbmNoLWthZmthMQ"
+	// kafkaMaxControllers is the size of the KRaft quorum, the other brokers
+	// only have the broker role.
+	kafkaMaxControllers = 3
+	// kafkaDestinationControllerPort is the controller listener of
+	// kafka-destination in KRaft mode.
+	kafkaDestinationControllerPort = 9091
+
+	// kafkaBasePort is the port of the first backbeat broker, the others
+	// listen every kafkaBrokerPortStep ports.
+	kafkaBasePort       = 9092
+	kafkaBrokerPortStep = 100
+	// kafkaMaxBrokers keeps the broker ports below the port of s3-data.
+	kafkaMaxBrokers = 9
+)
+
+// backbeatTopics are the topics created on the backbeat cluster by
+// setup-kafka.
+var backbeatTopics = []string{
+	"backbeat-lifecycle-bucket-tasks",
+	"backbeat-lifecycle-object-tasks",
+	"backbeat-bucket-notification",
+	"backbeat-replication",
+	"backbeat-data-mover",
+	"backbeat-replication-status",
+	"backbeat-replication-failed",
+	"backbeat-metrics",
+	"backbeat-gc",
+	"backbeat-ingestion",
+}
+
+// kafkaTopicPattern matches the legal kafka topic names.
+var kafkaTopicPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// kafkaBroker is a broker of the backbeat cluster.
+type kafkaBroker struct {
+	ID   int
+	Port int
+	// Controller brokers are voters of the KRaft quorum, listening on
+	// ControllerPort
+	Controller     bool
+	ControllerPort int
+	// Service is the compose service of the broker, kafka for the first one
+	Service string
+	// Properties is the server.properties file of the broker below the kafka
+	// config directory
+	Properties string
+}
+
+func (k KafkaConfig) validate() error {
+	if k.Mode != kafkaModeZookeeper && k.Mode != kafkaModeKRaft {
+		return fmt.Errorf("unknown mode %q (valid modes: %s, %s)", k.Mode, kafkaModeZookeeper, kafkaModeKRaft)
+	}
+	if k.Brokers < 1 || k.Brokers > kafkaMaxBrokers {
+		return fmt.Errorf("brokers must be between 1 and %d", kafkaMaxBrokers)
+	}
+	if k.Partitions < 1 || k.ReplicationFactor < 1 {
+		return errors.New("partitions and replication_factor must be at least 1")
+	}
+	if err := k.validateTopic(KafkaTopicConfig{
+		Partitions:        k.Partitions,
+		ReplicationFactor: k.ReplicationFactor,
+		Retention:         k.Retention,
+	}); err != nil {
+		return err
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(k.Topics)) {
+		if !kafkaTopicPattern.MatchString(name) {
+			return fmt.Errorf("invalid topic name %q, use letters, digits, ., - and _", name)
+		}
+		if err := k.validateTopic(k.Topics[name]); err != nil {
+			return fmt.Errorf("invalid topic %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validateTopic checks the settings of a topic, zero values meaning the
+// defaults.
+func (k KafkaConfig) validateTopic(t KafkaTopicConfig) error {
+	if t.Partitions < 0 {
+		return errors.New("partitions can't be negative")
+	}
+	if t.ReplicationFactor < 0 || t.ReplicationFactor > k.Brokers {
+		return fmt.Errorf("replication_factor must be between 1 and the %d brokers", k.Brokers)
+	}
+	if t.Retention != "" {
+		if d, err := time.ParseDuration(t.Retention); err != nil || d < time.Millisecond {
+			return fmt.Errorf("invalid retention %q, use a duration such as 72h or 30m", t.Retention)
+		}
+	}
+	return nil
+}
+
+// brokers returns the brokers of the backbeat cluster.
+func (k KafkaConfig) brokers() []kafkaBroker {
+	brokers := make([]kafkaBroker, 0, k.Brokers)
+	for id := 1; id <= k.Brokers; id++ {
+		port := kafkaBasePort + (id-1)*kafkaBrokerPortStep
+		b := kafkaBroker{
+			ID:             id,
+			Port:           port,
+			Controller:     k.KRaft() && id <= kafkaMaxControllers,
+			ControllerPort: port + 1,
+			Service:        "kafka",
+			Properties:     "server.backbeat.properties",
+		}
+		if id > 1 {
+			b.Service = fmt.Sprintf("kafka-%d", id)
+			b.Properties = fmt.Sprintf("server.backbeat-%d.properties", id)
+		}
+		brokers = append(brokers, b)
+	}
+	return brokers
+}
+
+// kafkaModeFile records the mode the kafka volumes were formatted in, next
+// to values.yaml. Brokers can't start on the metadata of the other mode.
+const kafkaModeFile = ".kafka-mode"
+
+// checkKafkaVolumesMode fails when kafka.mode changed since the kafka
+// volumes were created, and records the mode of new volumes.
+func checkKafkaVolumesMode(cfg EnvironmentConfig, envDir string) error {
+	features := cfg.Features
+	if !features.CrossRegionReplication.Enabled && !features.BucketNotifications.Enabled && !features.Lifecycle.Enabled {
+		return nil
+	}
+
+	path := filepath.Join(envDir, kafkaModeFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", kafkaModeFile, err)
+	}
+	if recorded := strings.TrimSpace(string(data)); recorded != "" {
+		if recorded != cfg.Kafka.Mode {
+			return fmt.Errorf("the kafka volumes were created in %s mode and can't be used in %s mode, "+
+				"remove them with `workbench down --volumes` or `workbench destroy`, or set kafka.mode back to %s",
+				recorded, cfg.Kafka.Mode, recorded)
+		}
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(cfg.Kafka.Mode+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", kafkaModeFile, err)
+	}
+	return nil
+}
+
+// KRaft reports whether the brokers run without zookeeper.
+func (k KafkaConfig) KRaft() bool {
+	return k.Mode == kafkaModeKRaft
+}
+
+// ControllerQuorum is the controller.quorum.voters of the backbeat cluster,
+// e.g. 1@127.0.0.1:9093.
+func (k KafkaConfig) ControllerQuorum() string {
+	var voters []string
+	for _, b := range k.brokers() {
+		if b.Controller {
+			voters = append(voters, fmt.Sprintf("%d@%s", b.ID, net.JoinHostPort("127.0.0.1", strconv.Itoa(b.ControllerPort))))
+		}
+	}
+	return strings.Join(voters, ",")
+}
+
+// kafkaStartCommand is the command of the brokers, formatting their storage
+// first in KRaft mode.
+func (k KafkaConfig) kafkaStartCommand() string {
+	start := "/opt/kafka/bin/kafka-server-start.sh /opt/kafka/config/server.properties"
+	if !k.KRaft() {
+		return start
+	}
+	format := "/opt/kafka/bin/kafka-storage.sh format --ignore-formatted --cluster-id " + kafkaClusterID +
+		" --config /opt/kafka/config/server.properties"
+	return fmt.Sprintf(`sh -c "%s && exec %s"`, format, start)
+}
+
+// Hosts is the bootstrap servers of the backbeat cluster, e.g.
+// 127.0.0.1:9092,127.0.0.1:9192.
+func (k KafkaConfig) Hosts() string {
+	var hosts []string
+	for _, b := range k.brokers() {
+		hosts = append(hosts, net.JoinHostPort("127.0.0.1", strconv.Itoa(b.Port)))
+	}
+	return strings.Join(hosts, ",")
+}
+
+// InternalReplicationFactor is the replication factor of the offsets and
+// transaction topics of the brokers.
+func (k KafkaConfig) InternalReplicationFactor() int {
+	return min(k.Brokers, 3)
+}
+
+// RetentionMs is the default retention of the brokers.
+func (k KafkaConfig) RetentionMs() int64 {
+	d, _ := time.ParseDuration(k.Retention)
+	return d.Milliseconds()
+}
+
+// topicSpecs returns the topics created by setup-kafka as
+// name:partitions:replication_factor:retention_ms, the backbeat topics first.
+func (k KafkaConfig) topicSpecs() []string {
+	names := slices.Clone(backbeatTopics)
+	for _, name := range slices.Sorted(maps.Keys(k.Topics)) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	specs := make([]string, 0, len(names))
+	for _, name := range names {
+		t := k.Topics[name]
+		if t.Partitions == 0 {
+			t.Partitions = k.Partitions
+		}
+		if t.ReplicationFactor == 0 {
+			t.ReplicationFactor = k.ReplicationFactor
+		}
+		if t.Retention == "" {
+			t.Retention = k.Retention
+		}
+		retention, _ := time.ParseDuration(t.Retention)
+		specs = append(specs, fmt.Sprintf("%s:%d:%d:%d", name, t.Partitions, t.ReplicationFactor, retention.Milliseconds()))
+	}
+	return specs
+}
+
+// kafkaBrokerConfig is the data of the server.properties template of a
+// broker.
+type kafkaBrokerConfig struct {
+	EnvironmentConfig
+	Broker kafkaBroker
+}
+
+// addKafkaOverrides declares the brokers after the first one, which
+// docker-compose.yaml declares, and the topics created by setup-kafka. In
+// KRaft mode the brokers format their storage before starting.
+func addKafkaOverrides(cfg EnvironmentConfig, envDir string, override *composeOverride) error {
+	svc := override.service("setup-kafka")
+	svc.Environment = map[string]string{
+		"TOPICS_TO_CREATE": strings.Join(cfg.Kafka.topicSpecs(), " "),
+		"KAFKA_BROKERS":    strconv.Itoa(cfg.Kafka.Brokers),
+	}
+
+	if cfg.Kafka.KRaft() {
+		override.service("kafka").Command = cfg.Kafka.kafkaStartCommand()
+		override.service("kafka-destination").Command = cfg.Kafka.kafkaStartCommand()
+	}
+
+	if cfg.Kafka.Brokers == 1 {
+		return nil
+	}
+
+	compose, err := loadComposeFile(envDir)
+	if err != nil {
+		return err
+	}
+	first, ok := compose.Services["kafka"]
+	if !ok {
+		return errors.New("kafka service not found in docker-compose.yaml")
+	}
+
+	if override.Volumes == nil {
+		override.Volumes = map[string]struct{}{}
+	}
+	for _, b := range cfg.Kafka.brokers()[1:] {
+		volume := fmt.Sprintf("kafka-data-%d", b.ID)
+		override.Volumes[volume] = struct{}{}
+
+		svc := override.service(b.Service)
+		svc.Build = first.Build
+		svc.ContainerName = "workbench-" + b.Service
+		svc.Restart = "on-failure"
+		svc.NetworkMode = "host"
+		svc.Command = cfg.Kafka.kafkaStartCommand()
+		svc.Volumes = []string{
+			fmt.Sprintf("./config/kafka/%s:/opt/kafka/config/server.properties:ro", b.Properties),
+			volume + ":/data",
+		}
+		svc.Profiles = first.Profiles
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testKafkaConfig() KafkaConfig {
+	return KafkaConfig{
+		Mode:              kafkaModeZookeeper,
+		Brokers:           3,
+		Partitions:        1,
+		ReplicationFactor: 1,
+		Retention:         "168h",
+	}
+}
+
+func TestKafkaConfigValidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		update       func(k *KafkaConfig)
+		errorMessage string
+	}{
+		{name: "defaults", update: func(k *KafkaConfig) {}},
+		{name: "kraft", update: func(k *KafkaConfig) { k.Mode = kafkaModeKRaft }},
+		{name: "unknown mode", update: func(k *KafkaConfig) { k.Mode = "raft" }, errorMessage: "unknown mode"},
+		{name: "no broker", update: func(k *KafkaConfig) { k.Brokers = 0 }, errorMessage: "brokers must be between"},
+		{name: "too many brokers", update: func(k *KafkaConfig) { k.Brokers = kafkaMaxBrokers + 1 }, errorMessage: "brokers must be between"},
+		{name: "no partition", update: func(k *KafkaConfig) { k.Partitions = 0 }, errorMessage: "at least 1"},
+		{name: "replication above brokers", update: func(k *KafkaConfig) { k.ReplicationFactor = 4 }, errorMessage: "replication_factor must be between"},
+		{name: "invalid retention", update: func(k *KafkaConfig) { k.Retention = "7d" }, errorMessage: "invalid retention"},
+		{
+			name: "topic settings",
+			update: func(k *KafkaConfig) {
+				k.Topics = map[string]KafkaTopicConfig{"backbeat-gc": {Partitions: 4, Retention: "1h"}}
+			},
+		},
+		{
+			name:         "invalid topic name",
+			update:       func(k *KafkaConfig) { k.Topics = map[string]KafkaTopicConfig{"my topic": {}} },
+			errorMessage: "invalid topic name",
+		},
+		{
+			name:         "invalid topic replication",
+			update:       func(k *KafkaConfig) { k.Topics = map[string]KafkaTopicConfig{"backbeat-gc": {ReplicationFactor: 5}} },
+			errorMessage: "invalid topic backbeat-gc",
+		},
+		{
+			name:         "negative topic partitions",
+			update:       func(k *KafkaConfig) { k.Topics = map[string]KafkaTopicConfig{"backbeat-gc": {Partitions: -1}} },
+			errorMessage: "can't be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := testKafkaConfig()
+			tt.update(&k)
+			err := k.validate()
+			if tt.errorMessage == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorMessage) {
+				t.Fatalf("expected an error containing %q, got %v", tt.errorMessage, err)
+			}
+		})
+	}
+}
+
+func TestKafkaTopicSpecs(t *testing.T) {
+	k := testKafkaConfig()
+	k.Topics = map[string]KafkaTopicConfig{
+		"backbeat-replication": {Partitions: 8, ReplicationFactor: 3},
+		"backbeat-gc":          {Retention: "1h"},
+		"custom-b":             {},
+		"custom-a":             {Partitions: 2},
+	}
+
+	specs := k.topicSpecs()
+	if len(specs) != len(backbeatTopics)+2 {
+		t.Fatalf("expected the backbeat topics and the custom ones, got %q", specs)
+	}
+	// Custom topics come after the backbeat ones, sorted
+	if tail := specs[len(backbeatTopics):]; !slices.Equal(tail, []string{"custom-a:2:1:604800000", "custom-b:1:1:604800000"}) {
+		t.Errorf("unexpected custom topics %q", tail)
+	}
+	for _, expected := range []string{
+		"backbeat-lifecycle-bucket-tasks:1:1:604800000",
+		"backbeat-replication:8:3:604800000",
+		"backbeat-gc:1:1:3600000",
+	} {
+		if !slices.Contains(specs, expected) {
+			t.Errorf("expected %s in %q", expected, specs)
+		}
+	}
+}
+
+func TestKafkaControllerQuorum(t *testing.T) {
+	tests := []struct {
+		mode     string
+		brokers  int
+		expected string
+	}{
+		{mode: kafkaModeZookeeper, brokers: 3, expected: ""},
+		{mode: kafkaModeKRaft, brokers: 1, expected: "1@127.0.0.1:9093"},
+		{mode: kafkaModeKRaft, brokers: 5, expected: "1@127.0.0.1:9093,2@127.0.0.1:9193,3@127.0.0.1:9293"},
+	}
+
+	for _, tt := range tests {
+		k := KafkaConfig{Mode: tt.mode, Brokers: tt.brokers}
+		if quorum := k.ControllerQuorum(); quorum != tt.expected {
+			t.Errorf("%s with %d brokers: got %q, want %q", tt.mode, tt.brokers, quorum, tt.expected)
+		}
+	}
+}
+
+func TestCheckKafkaVolumesMode(t *testing.T) {
+	withKafka := func(mode string) EnvironmentConfig {
+		var cfg EnvironmentConfig
+		cfg.Features.Lifecycle.Enabled = true
+		cfg.Kafka.Mode = mode
+		return cfg
+	}
+
+	tests := []struct {
+		name         string
+		recorded     string
+		cfg          EnvironmentConfig
+		expected     string
+		errorMessage string
+	}{
+		{name: "new volumes", cfg: withKafka(kafkaModeKRaft), expected: "kraft\n"},
+		{name: "same mode", recorded: "zookeeper\n", cfg: withKafka(kafkaModeZookeeper), expected: "zookeeper\n"},
+		{name: "mode changed", recorded: "zookeeper\n", cfg: withKafka(kafkaModeKRaft), expected: "zookeeper\n", errorMessage: "created in zookeeper mode"},
+		{name: "kafka unused", recorded: "zookeeper\n", cfg: EnvironmentConfig{Kafka: KafkaConfig{Mode: kafkaModeKRaft}}, expected: "zookeeper\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envDir := t.TempDir()
+			path := filepath.Join(envDir, kafkaModeFile)
+			if tt.recorded != "" {
+				if err := os.WriteFile(path, []byte(tt.recorded), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := checkKafkaVolumesMode(tt.cfg, envDir)
+			if tt.errorMessage == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.errorMessage != "" && (err == nil || !strings.Contains(err.Error(), tt.errorMessage)) {
+				t.Fatalf("expected an error containing %q, got %v", tt.errorMessage, err)
+			}
+
+			data, _ := os.ReadFile(path)
+			if string(data) != tt.expected {
+				t.Errorf("expected %q recorded, got %q", tt.expected, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,524 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type KafkaCmd struct {
+	Topics KafkaTopicsCmd `cmd:"" help:"List the topics of a kafka cluster."`
+	Lag    KafkaLagCmd    `cmd:"" help:"Show the lag of the consumer groups of a kafka cluster."`
+	Peek   KafkaPeekCmd   `cmd:"" help:"Print the last messages of a topic, decoded as JSON."`
+}
+
+type KafkaTopicsCmd struct {
+	EnvDir  string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name    string `help:"Name of the environment. default: 'default'" short:"n"`
+	Cluster string `help:"Kafka cluster: backbeat (kafka, port 9092) or destination (kafka-destination, port 9094)." enum:"backbeat,destination" default:"backbeat"`
+	All     bool   `help:"Also list the internal topics of kafka, e.g. __consumer_offsets." short:"a"`
+}
+
+type KafkaLagCmd struct {
+	EnvDir     string   `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name       string   `help:"Name of the environment. default: 'default'" short:"n"`
+	Cluster    string   `help:"Kafka cluster: backbeat (kafka, port 9092) or destination (kafka-destination, port 9094)." enum:"backbeat,destination" default:"backbeat"`
+	Group      []string `help:"Only show this consumer group, e.g. backbeat-qp-oplog-group. Can be repeated." short:"g"`
+	Partitions bool     `help:"Show the lag of each partition instead of each topic."`
+}
+
+type KafkaPeekCmd struct {
+	EnvDir    string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name      string `help:"Name of the environment. default: 'default'" short:"n"`
+	Cluster   string `help:"Kafka cluster: backbeat (kafka, port 9092) or destination (kafka-destination, port 9094)." enum:"backbeat,destination" default:"backbeat"`
+	Topic     string `arg:"" help:"Topic to read, e.g. backbeat-replication."`
+	Count     int    `help:"Number of messages to print." short:"c" default:"10"`
+	Partition int    `help:"Only read this partition, all of them by default." default:"-1"`
+	JSON      bool   `help:"Print the messages as JSON, one per line."`
+}
+
+const (
+	kafkaClusterBackbeat    = "backbeat"
+	kafkaClusterDestination = "destination"
+)
+
+// kafkaPeekScript prints the messages of a topic from count messages before
+// the end of each partition, as the console consumer can only start at an
+// offset of a given partition.
+const kafkaPeekScript = `set -eo pipefail
+bootstrap=$1 config=$2 topic=$3 count=$4 only=$5
+offsets_config= consumer_config=
+if [[ -n "$config" ]]; then
+    offsets_config="--command-config $config"
+    consumer_config="--consumer.config $config"
+fi
+kafka-get-offsets.sh --bootstrap-server "$bootstrap" $offsets_config --topic "$topic" |
+while IFS=: read -r _ partition end; do
+    if (( only >= 0 && partition != only )); then
+        continue
+    fi
+    start=$(( end > count ? end - count : 0 ))
+    if (( end == start )); then
+        continue
+    fi
+    kafka-console-consumer.sh --bootstrap-server "$bootstrap" $consumer_config --topic "$topic" \
+        --partition "$partition" --offset "$start" --max-messages $(( end - start )) --timeout-ms 10000 \
+        --property print.timestamp=true --property print.partition=true \
+        --property print.offset=true --property print.key=true
+done`
+
+// kafkaCluster is a kafka cluster of an environment, reached from one of its
+// brokers.
+type kafkaCluster struct {
+	service   string
+	bootstrap string
+	// commandConfig holds the client settings of the cluster, if any
+	commandConfig string
+	// topics are the topics created by the setup service of the cluster
+	topics []string
+
+	orch Orchestrator
+}
+
+func openKafkaCluster(envDir, name, cluster string) (*kafkaCluster, error) {
+	rc := RuntimeConfigFromFlags(envDir, name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return nil, fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var k kafkaCluster
+	switch cluster {
+	case kafkaClusterDestination:
+		if !cfg.Features.BucketNotifications.Enabled {
+			return nil, errors.New("kafka-destination runs with bucket_notifications, enable it in values.yaml")
+		}
+		k = kafkaCluster{
+			service:       notificationsService,
+			bootstrap:     notificationsBootstrap,
+			commandConfig: notificationsConfig,
+			topics:        cfg.Features.BucketNotifications.localTopics(),
+		}
+	default:
+		features := cfg.Features
+		if !features.CrossRegionReplication.Enabled && !features.BucketNotifications.Enabled && !features.Lifecycle.Enabled {
+			return nil, errors.New("kafka runs with cross_region_replication, bucket_notifications or lifecycle, enable one of them in values.yaml")
+		}
+		k = kafkaCluster{
+			service:   "kafka",
+			bootstrap: cfg.Kafka.Hosts(),
+		}
+		for _, spec := range cfg.Kafka.topicSpecs() {
+			topic, _, _ := strings.Cut(spec, ":")
+			k.topics = append(k.topics, topic)
+		}
+	}
+
+	k.orch, err = newOrchestrator(cfg, envPath)
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// clientArgs returns the bootstrap server and client config flags of a kafka
+// tool, configFlag being its flag of the client config.
+func (k *kafkaCluster) clientArgs(configFlag string) []string {
+	args := []string{"--bootstrap-server", k.bootstrap}
+	if k.commandConfig != "" {
+		args = append(args, configFlag, k.commandConfig)
+	}
+	return args
+}
+
+// run runs a command in the broker container and returns its output.
+func (k *kafkaCluster) run(ctx context.Context, command ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	code, err := k.orch.Exec(ctx, ExecOptions{
+		Service: k.service,
+		Command: command,
+		Stdout:  &stdout,
+		Stderr:  &stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s in %s: %w", command[0], k.service, err)
+	}
+	if code != 0 {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			log.Debug().Str("stderr", msg).Msg("Kafka command failed")
+		}
+		return nil, fmt.Errorf("%s exited with code %d in %s, is the environment running?", command[0], code, k.service)
+	}
+	return stdout.Bytes(), nil
+}
+
+// kafkaTopic is a topic described by kafka-topics.sh.
+type kafkaTopic struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	// UnderReplicated counts the partitions with fewer in-sync replicas than
+	// replicas
+	UnderReplicated int
+	Configs         map[string]string
+}
+
+func (c *KafkaTopicsCmd) Run() error {
+	k, err := openKafkaCluster(c.EnvDir, c.Name, c.Cluster)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	out, err := k.run(ctx, append([]string{"kafka-topics.sh", "--describe"}, k.clientArgs("--command-config")...)...)
+	if err != nil {
+		return err
+	}
+	topics := parseKafkaTopics(out)
+
+	var missing []string
+	for _, name := range k.topics {
+		if !slices.ContainsFunc(topics, func(t kafkaTopic) bool { return t.Name == name }) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Warn().Strs("topics", missing).Msg("Topics of the setup service are missing")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TOPIC\tPARTITIONS\tREPLICATION FACTOR\tUNDER-REPLICATED\tRETENTION")
+	for _, t := range topics {
+		if strings.HasPrefix(t.Name, "__") && !c.All {
+			continue
+		}
+		retention := "-"
+		if ms, err := strconv.ParseInt(t.Configs["retention.ms"], 10, 64); err == nil {
+			retention = (time.Duration(ms) * time.Millisecond).String()
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", t.Name, t.Partitions, t.ReplicationFactor, t.UnderReplicated, retention)
+	}
+	return w.Flush()
+}
+
+// parseKafkaTopics parses the output of kafka-topics.sh --describe, a line
+// per topic followed by a line per partition, of tab separated key: value
+// fields.
+func parseKafkaTopics(out []byte) []kafkaTopic {
+	var topics []kafkaTopic
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := map[string]string{}
+		for _, field := range strings.Split(strings.TrimSpace(scanner.Text()), "\t") {
+			key, value, _ := strings.Cut(field, ":")
+			fields[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		if fields["Topic"] == "" {
+			continue
+		}
+
+		if _, ok := fields["PartitionCount"]; ok {
+			t := kafkaTopic{Name: fields["Topic"], Configs: map[string]string{}}
+			t.Partitions, _ = strconv.Atoi(fields["PartitionCount"])
+			t.ReplicationFactor, _ = strconv.Atoi(fields["ReplicationFactor"])
+			for _, config := range strings.Split(fields["Configs"], ",") {
+				if key, value, ok := strings.Cut(config, "="); ok {
+					t.Configs[key] = value
+				}
+			}
+			topics = append(topics, t)
+			continue
+		}
+
+		if _, ok := fields["Partition"]; ok && len(topics) > 0 {
+			replicas := strings.Split(fields["Replicas"], ",")
+			isr := strings.Split(fields["Isr"], ",")
+			if len(isr) < len(replicas) {
+				topics[len(topics)-1].UnderReplicated++
+			}
+		}
+	}
+	slices.SortFunc(topics, func(a, b kafkaTopic) int { return strings.Compare(a.Name, b.Name) })
+	return topics
+}
+
+// kafkaGroupLag is the lag of a consumer group on a partition of a topic.
+type kafkaGroupLag struct {
+	Group     string
+	Topic     string
+	Partition int
+	// Current and End are the committed and last offsets, -1 when unknown
+	Current int64
+	End     int64
+	Lag     int64
+	Member  string
+}
+
+func (c *KafkaLagCmd) Run() error {
+	k, err := openKafkaCluster(c.EnvDir, c.Name, c.Cluster)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	command := append([]string{"kafka-consumer-groups.sh", "--describe"}, k.clientArgs("--command-config")...)
+	if len(c.Group) == 0 {
+		command = append(command, "--all-groups")
+	}
+	for _, group := range c.Group {
+		command = append(command, "--group", group)
+	}
+	out, err := k.run(ctx, command...)
+	if err != nil {
+		return err
+	}
+
+	lags := parseKafkaLag(out)
+	if len(lags) == 0 {
+		log.Info().Msg("No consumer group has committed offsets")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if c.Partitions {
+		_, _ = fmt.Fprintln(w, "GROUP\tTOPIC\tPARTITION\tCURRENT OFFSET\tEND OFFSET\tLAG\tMEMBER")
+		for _, l := range lags {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n",
+				l.Group, l.Topic, l.Partition, formatOffset(l.Current), formatOffset(l.End), formatOffset(l.Lag), l.Member)
+		}
+		return w.Flush()
+	}
+
+	_, _ = fmt.Fprintln(w, "GROUP\tTOPIC\tPARTITIONS\tLAG\tMEMBERS")
+	for i := 0; i < len(lags); {
+		group, topic := lags[i].Group, lags[i].Topic
+		var partitions int
+		var lag int64
+		var members []string
+		for ; i < len(lags) && lags[i].Group == group && lags[i].Topic == topic; i++ {
+			partitions++
+			if lags[i].Lag > 0 {
+				lag += lags[i].Lag
+			}
+			if lags[i].Member != "-" && !slices.Contains(members, lags[i].Member) {
+				members = append(members, lags[i].Member)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\n", group, topic, partitions, lag, len(members))
+	}
+	return w.Flush()
+}
+
+// parseKafkaLag parses the tables of kafka-consumer-groups.sh --describe,
+// sorted by group, topic and partition.
+func parseKafkaLag(out []byte) []kafkaGroupLag {
+	var lags []kafkaGroupLag
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		// GROUP TOPIC PARTITION CURRENT-OFFSET LOG-END-OFFSET LAG CONSUMER-ID HOST CLIENT-ID
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		partition, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		lags = append(lags, kafkaGroupLag{
+			Group:     fields[0],
+			Topic:     fields[1],
+			Partition: partition,
+			Current:   parseOffset(fields[3]),
+			End:       parseOffset(fields[4]),
+			Lag:       parseOffset(fields[5]),
+			Member:    fields[6],
+		})
+	}
+	slices.SortFunc(lags, func(a, b kafkaGroupLag) int {
+		if c := strings.Compare(a.Group, b.Group); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.Topic, b.Topic); c != 0 {
+			return c
+		}
+		return a.Partition - b.Partition
+	})
+	return lags
+}
+
+func parseOffset(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func formatOffset(n int64) string {
+	if n < 0 {
+		return "-"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// kafkaMessage is a message printed by the console consumer.
+type kafkaMessage struct {
+	Partition int             `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Timestamp time.Time       `json:"timestamp"`
+	Key       *string         `json:"key"`
+	Value     json.RawMessage `json:"value"`
+}
+
+func (c *KafkaPeekCmd) Run() error {
+	if c.Count < 1 {
+		return errors.New("--count must be at least 1")
+	}
+
+	k, err := openKafkaCluster(c.EnvDir, c.Name, c.Cluster)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	out, err := k.run(ctx, "bash", "-c", kafkaPeekScript, "bash",
+		k.bootstrap, k.commandConfig, c.Topic, strconv.Itoa(c.Count), strconv.Itoa(c.Partition))
+	if err != nil {
+		return err
+	}
+
+	messages := parseKafkaMessages(out)
+	// The last messages of each partition were read, keep the last ones of
+	// the topic
+	slices.SortStableFunc(messages, func(a, b kafkaMessage) int { return a.Timestamp.Compare(b.Timestamp) })
+	if len(messages) > c.Count {
+		messages = messages[len(messages)-c.Count:]
+	}
+	if len(messages) == 0 {
+		log.Info().Str("topic", c.Topic).Msg("No messages")
+		return nil
+	}
+
+	for _, m := range messages {
+		if c.JSON {
+			line, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+			continue
+		}
+
+		key := "null"
+		if m.Key != nil {
+			key = *m.Key
+		}
+		fmt.Printf("partition=%d offset=%d time=%s key=%s\n", m.Partition, m.Offset, m.Timestamp.Format(time.RFC3339Nano), key)
+		var value bytes.Buffer
+		if err := json.Indent(&value, m.Value, "", "  "); err != nil {
+			value.Write(m.Value)
+		}
+		fmt.Println(value.String())
+	}
+	return nil
+}
+
+// parseKafkaMessages parses the console consumer lines of timestamp,
+// partition, offset, key and value separated by tabs.
+func parseKafkaMessages(out []byte) []kafkaMessage {
+	var messages []kafkaMessage
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 5)
+		if len(fields) < 5 {
+			continue
+		}
+		var m kafkaMessage
+		_, ts, _ := strings.Cut(fields[0], ":")
+		if ms, err := strconv.ParseInt(ts, 10, 64); err == nil {
+			m.Timestamp = time.UnixMilli(ms).UTC()
+		}
+		partition, err := strconv.Atoi(strings.TrimPrefix(fields[1], "Partition:"))
+		if err != nil {
+			continue
+		}
+		m.Partition = partition
+		m.Offset = parseOffset(strings.TrimPrefix(fields[2], "Offset:"))
+		if fields[3] != "null" {
+			m.Key = &fields[3]
+		}
+		m.Value = decodeKafkaValue(fields[4])
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// decodeKafkaValue returns a message value as JSON, decoding the JSON
+// documents that backbeat embeds as strings, e.g. the object metadata of
+// backbeat-replication entries. Other values become JSON strings.
+func decodeKafkaValue(value string) json.RawMessage {
+	var v any
+	dec := json.NewDecoder(strings.NewReader(value))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil || dec.More() {
+		data, _ := json.Marshal(value)
+		return data
+	}
+
+	data, err := json.Marshal(decodeEmbeddedJSON(v))
+	if err != nil {
+		data, _ = json.Marshal(value)
+	}
+	return data
+}
+
+func decodeEmbeddedJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			v[key] = decodeEmbeddedJSON(value)
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = decodeEmbeddedJSON(value)
+		}
+	case string:
+		trimmed := strings.TrimSpace(v)
+		if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+			return v
+		}
+		var embedded any
+		dec := json.NewDecoder(strings.NewReader(trimmed))
+		dec.UseNumber()
+		if dec.Decode(&embedded) == nil && !dec.More() {
+			return decodeEmbeddedJSON(embedded)
+		}
+	}
+	return v
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+type LifecycleCmd struct {
+	Trigger LifecycleTriggerCmd `cmd:"" help:"Run the lifecycle conductor once, without waiting for its cron rule."`
+}
+
+type LifecycleTriggerCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+}
+
+// lifecycleConductorScript lists the buckets with lifecycle rules and queues
+// their tasks once, like a run of the lifecycle-conductor program. It runs
+// from the backbeat checkout, next to the program.
+const lifecycleConductorScript = `const config = require('./lib/Config');
+const LifecycleConductor = require('./extensions/lifecycle/conductor/LifecycleConductor');
+
+const { zookeeper, kafka, extensions, transport } = config;
+const conductor = new LifecycleConductor(zookeeper, kafka, extensions.lifecycle, extensions.replication, transport);
+
+conductor.init(err => {
+    if (err) {
+        console.error('failed to initialize the conductor:', err.message || err);
+        process.exit(1);
+    }
+    conductor.processBuckets(err => {
+        if (err) {
+            console.error('failed to process the buckets:', err.message || err);
+        }
+        conductor.stop(() => process.exit(err ? 1 : 0));
+    });
+});
+`
+
+func (c *LifecycleTriggerCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+	if !cfg.Features.Lifecycle.Enabled {
+		return errors.New("the lifecycle conductor runs with lifecycle, enable it in values.yaml")
+	}
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	log.Info().Str("cron_rule", cfg.Features.Lifecycle.CronRule).Msg("Triggering a lifecycle conductor run")
+
+	code, err := orch.Exec(ctx, ExecOptions{
+		Service: "backbeat",
+		Command: []string{"bash", "-c", "source /conf/env && exec node -"},
+		Stdin:   strings.NewReader(lifecycleConductorScript),
+		Stdout:  os.Stdout,
+		Stderr:  os.Stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to run the lifecycle conductor in backbeat: %w", err)
+	}
+	if code != 0 {
+		return fmt.Errorf("lifecycle conductor exited with code %d, is the environment running?", code)
+	}
+
+	log.Info().Msg("Lifecycle conductor run completed, the bucket processor now handles the queued buckets")
+	return nil
+}
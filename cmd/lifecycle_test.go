@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLifecycleTriggerCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t, "lifecycle")
+
+	cmd := LifecycleTriggerCmd{EnvDir: envDir, Name: DefaultEnvName}
+	if _, err := captureStdout(t, cmd.Run); err != nil {
+		t.Fatalf("lifecycle trigger failed: %v", err)
+	}
+	assertCalls(t, orch, "exec backbeat bash -c source /conf/env && exec node -")
+
+	orch.ExitCode = 1
+	if _, err := captureStdout(t, cmd.Run); err == nil {
+		t.Error("expected a failed conductor run to return an error")
+	}
+}
+
+func TestLifecycleTriggerCmdDisabled(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t)
+
+	cmd := LifecycleTriggerCmd{EnvDir: envDir, Name: DefaultEnvName}
+	if err := cmd.Run(); err == nil {
+		t.Fatal("expected the trigger to fail without lifecycle")
+	}
+	assertCalls(t, orch)
+}
+
+func TestLifecycleOverrides(t *testing.T) {
+	tests := []struct {
+		name          string
+		daysAsSeconds bool
+		expected      string
+	}{
+		{name: "real time", daysAsSeconds: false, expected: ""},
+		{name: "days as seconds", daysAsSeconds: true, expected: "86400"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg EnvironmentConfig
+			cfg.Features.Lifecycle.DaysAsSeconds = tt.daysAsSeconds
+
+			override := composeOverride{Services: map[string]*composeOverrideService{}}
+			addLifecycleOverrides(cfg, &override)
+			var factor string
+			if svc, ok := override.Services["cloudserver"]; ok {
+				factor = svc.Environment["TIME_PROGRESSION_FACTOR"]
+			}
+			if factor != tt.expected {
+				t.Errorf("got TIME_PROGRESSION_FACTOR %q, want %q", factor, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+type LockCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+	NoPull bool   `help:"Don't pull missing images, only lock the images present locally."`
+}
+
+// imageLockFile pins the images of values.yaml to digests. It is written
+// by workbench lock next to values.yaml.
+const imageLockFile = "values.lock"
+
+type imageLock struct {
+	// Images maps the references of values.yaml to digest-pinned references
+	Images map[string]string `yaml:"images"`
+	// Unlocked lists the images that could not be resolved when locking
+	Unlocked []string `yaml:"unlocked,omitempty"`
+}
+
+func (c *LockCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	lock := imageLock{Images: map[string]string{}}
+	for _, image := range cfg.images() {
+		pinned, err := resolveImage(ctx, image, !c.NoPull)
+		if err != nil {
+			log.Warn().Err(err).Str("image", image).Msg("Failed to lock image")
+			lock.Unlocked = append(lock.Unlocked, image)
+			continue
+		}
+		log.Debug().Str("image", image).Str("pinned", pinned).Msg("Locked image")
+		lock.Images[image] = pinned
+	}
+
+	if err := writeImageLock(envPath, lock); err != nil {
+		return err
+	}
+
+	log.Info().
+		Int("locked", len(lock.Images)).
+		Strs("unlocked", lock.Unlocked).
+		Msgf("Images locked in %s, run workbench configure to apply", imageLockFile)
+	return nil
+}
+
+// resolveImage returns the digest-pinned reference of an image, pulling it
+// first when it is missing and pull is set.
+func resolveImage(ctx context.Context, ref string, pull bool) (string, error) {
+	if !imageExists(ctx, ref) {
+		if !pull {
+			return "", fmt.Errorf("image %s is missing locally", ref)
+		}
+		if err := pullImage(ctx, ref); err != nil {
+			return "", err
+		}
+	}
+
+	digest, err := imageDigest(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+
+	_, sum, found := strings.Cut(digest, "@")
+	if !found {
+		// Only an image ID, e.g. an image built locally that was never pushed
+		return "", fmt.Errorf("image %s has no repository digest", ref)
+	}
+
+	// Keep the tag for readability, docker ignores it when a digest is set
+	repo, _, _ := strings.Cut(ref, "@")
+	return repo + "@" + sum, nil
+}
+
+func writeImageLock(envPath string, lock imageLock) error {
+	buf := bytes.NewBufferString("# Generated by workbench lock, do not edit.\n")
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(lock); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", imageLockFile, err)
+	}
+
+	path := filepath.Join(envPath, imageLockFile)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadImageLock reads the lock of an environment. An environment without a
+// lock gets an empty one.
+func loadImageLock(envPath string) (imageLock, error) {
+	var lock imageLock
+	data, err := os.ReadFile(filepath.Join(envPath, imageLockFile))
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return lock, fmt.Errorf("failed to read %s: %w", imageLockFile, err)
+	}
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return lock, fmt.Errorf("failed to parse %s: %w", imageLockFile, err)
+	}
+	return lock, nil
+}
+
+// applyImageLock replaces the images of cfg with their pinned references from
+// the lock of the environment. Images changed in values.yaml after locking are
+// left floating.
+func applyImageLock(cfg EnvironmentConfig, envPath string) (EnvironmentConfig, error) {
+	lock, err := loadImageLock(envPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, ref := range cfg.imageRefs() {
+		if pinned, ok := lock.Images[*ref]; ok {
+			*ref = pinned
+		} else if *ref != "" && len(lock.Images) > 0 && !slices.Contains(lock.Unlocked, *ref) {
+			log.Warn().Str("image", *ref).Msgf("Image is not in %s, run workbench lock to pin it", imageLockFile)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyImageLock(t *testing.T) {
+	const (
+		cloudserver = "ghcr.io/scality/cloudserver:9.0.1"
+		vault       = "ghcr.io/scality/vault:8.0.0"
+		redis       = "redis:8"
+		pinned      = "ghcr.io/scality/cloudserver:9.0.1@sha256:0123"
+	)
+
+	tests := []struct {
+		name     string
+		lock     *imageLock
+		expected map[string]string
+	}{
+		{
+			name:     "no lock",
+			expected: map[string]string{"cloudserver": cloudserver, "vault": vault, "redis": redis},
+		},
+		{
+			name: "pinned and floating images",
+			lock: &imageLock{
+				Images:   map[string]string{cloudserver: pinned},
+				Unlocked: []string{redis},
+			},
+			expected: map[string]string{"cloudserver": pinned, "vault": vault, "redis": redis},
+		},
+		{
+			name:     "image changed after locking",
+			lock:     &imageLock{Images: map[string]string{"ghcr.io/scality/cloudserver:9.0.0": "old@sha256:abcd"}},
+			expected: map[string]string{"cloudserver": cloudserver, "vault": vault, "redis": redis},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			envPath := t.TempDir()
+			if tt.lock != nil {
+				if err := writeImageLock(envPath, *tt.lock); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var cfg EnvironmentConfig
+			cfg.Cloudserver.Image = cloudserver
+			cfg.Vault.Image = vault
+			cfg.Redis.Image = redis
+
+			got, err := applyImageLock(cfg, envPath)
+			if err != nil {
+				t.Fatalf("applyImageLock failed: %v", err)
+			}
+			actual := map[string]string{"cloudserver": got.Cloudserver.Image, "vault": got.Vault.Image, "redis": got.Redis.Image}
+			for name, image := range tt.expected {
+				if actual[name] != image {
+					t.Errorf("expected %s image %s, got %s", name, image, actual[name])
+				}
+			}
+			if cfg.Cloudserver.Image != cloudserver {
+				t.Error("applyImageLock changed the config it was given")
+			}
+		})
+	}
+}
+
+func TestApplyImageLockInvalid(t *testing.T) {
+	envPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(envPath, imageLockFile), []byte("images: [not, a, map]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := applyImageLock(EnvironmentConfig{}, envPath); err == nil {
+		t.Fatal("expected an invalid lock to fail")
+	}
+}
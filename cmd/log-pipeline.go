@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logRecord is a single log line, parsed as werelogs JSON when possible.
+type logRecord struct {
+	Service    string
+	Source     string
+	Time       time.Time
+	Level      string
+	Message    string
+	ReqID      string
+	Fields     map[string]any
+	Raw        string
+	Structured bool
+}
+
+// werelogsKeys are rendered separately from the other fields of a record.
+var werelogsKeys = []string{"time", "level", "message", "req_id", "name", "hostname", "pid"}
+
+// parseLogLine parses a log line of the given service. Lines that are not
+// werelogs JSON are kept as raw records.
+func parseLogLine(service, source, line string) logRecord {
+	rec := logRecord{Service: service, Source: source, Raw: line}
+
+	// docker compose logs --timestamps prefixes lines with an RFC3339 timestamp
+	payload := line
+	if ts, rest, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, ts); err == nil {
+			rec.Time = t
+			payload = rest
+		}
+	}
+
+	payload = strings.TrimSpace(payload)
+	if !strings.HasPrefix(payload, "{") {
+		return rec
+	}
+
+	fields := map[string]any{}
+	if err := json.Unmarshal([]byte(payload), &fields); err != nil {
+		return rec
+	}
+
+	// nginx only has the request time in seconds, werelogs uses milliseconds
+	if elapsed, ok := fields["elapsed_s"].(float64); ok {
+		if _, ok := fields["elapsed_ms"]; !ok {
+			fields["elapsed_ms"] = elapsed * 1000
+		}
+		delete(fields, "elapsed_s")
+	}
+
+	rec.Structured = true
+	rec.Fields = fields
+	rec.Level, _ = fields["level"].(string)
+	rec.Message, _ = fields["message"].(string)
+	rec.ReqID, _ = fields["req_id"].(string)
+	if t, ok := parseRecordTime(fields["time"]); ok {
+		rec.Time = t
+	}
+
+	return rec
+}
+
+// parseRecordTime decodes werelogs epoch milliseconds, nginx epoch seconds
+// or RFC3339 strings.
+func parseRecordTime(value any) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		// Anything below 1e11 can't be milliseconds of a recent date
+		if v < 1e11 {
+			return time.UnixMilli(int64(v * 1000)), true
+		}
+		return time.UnixMilli(int64(v)), true
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// MatchesReqID reports whether the record belongs to the given request.
+// werelogs joins the IDs of nested requests with ':' so every part is checked.
+func (r logRecord) MatchesReqID(id string) bool {
+	if !r.Structured {
+		return strings.Contains(r.Raw, id)
+	}
+	for _, part := range strings.Split(r.ReqID, ":") {
+		if part == id {
+			return true
+		}
+	}
+	return false
+}
+
+// splitComposeLine splits a docker compose logs line into the service and
+// the log line. Container names are mapped back to the services declaring
+// them, e.g. workbench-setup-cloudserver to setup-rate-limiting-svc-user.
+func splitComposeLine(compose composeFile, line string) (string, string) {
+	prefix, rest, ok := strings.Cut(line, " | ")
+	if !ok {
+		return "", line
+	}
+	container := strings.TrimSpace(prefix)
+	if service, err := compose.resolveService(container); err == nil {
+		return service, rest
+	}
+	return container, rest
+}
+
+const (
+	logFormatPretty = "pretty"
+	logFormatRaw    = "raw"
+	logFormatJSON   = "json"
+)
+
+// logPipeline filters and renders the records of concurrent log sources.
+type logPipeline struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	color  bool
+	grep   *regexp.Regexp
+	reqID  string
+	since  time.Time
+	until  time.Time
+
+	// When sorted is set records are buffered and rendered in time order by Flush
+	sorted  bool
+	records []logRecord
+}
+
+// HandleContainer processes a line of the logs of a service container.
+func (p *logPipeline) HandleContainer(service, line string) {
+	p.handle(parseLogLine(service, service, line))
+}
+
+// HandleFile processes a line read from a log file of a service.
+func (p *logPipeline) HandleFile(service, source, line string) {
+	p.handle(parseLogLine(service, source, line))
+}
+
+func (p *logPipeline) handle(rec logRecord) {
+	if p.grep != nil && !p.grep.MatchString(rec.Raw) {
+		return
+	}
+	if p.reqID != "" && !rec.MatchesReqID(p.reqID) {
+		return
+	}
+	if !rec.Time.IsZero() {
+		if !p.since.IsZero() && rec.Time.Before(p.since) {
+			return
+		}
+		if !p.until.IsZero() && rec.Time.After(p.until) {
+			return
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.sorted {
+		p.records = append(p.records, rec)
+		return
+	}
+	p.render(rec)
+}
+
+// Flush renders buffered records ordered by time.
+func (p *logPipeline) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sort.SliceStable(p.records, func(i, j int) bool {
+		return p.records[i].Time.Before(p.records[j].Time)
+	})
+	for _, rec := range p.records {
+		p.render(rec)
+	}
+	p.records = nil
+}
+
+func (p *logPipeline) render(rec logRecord) {
+	switch p.format {
+	case logFormatJSON:
+		p.renderJSON(rec)
+	case logFormatRaw:
+		p.renderRaw(rec)
+	default:
+		p.renderPretty(rec)
+	}
+}
+
+func (p *logPipeline) renderRaw(rec logRecord) {
+	if rec.Source == "" {
+		_, _ = fmt.Fprintln(p.out, rec.Raw)
+		return
+	}
+	_, _ = fmt.Fprintf(p.out, "%s | %s\n", rec.Source, rec.Raw)
+}
+
+func (p *logPipeline) renderJSON(rec logRecord) {
+	fields := rec.Fields
+	if fields == nil {
+		fields = map[string]any{"message": rec.Raw}
+	}
+	fields["service"] = rec.Service
+	fields["source"] = rec.Source
+	data, err := json.Marshal(fields)
+	if err != nil {
+		p.renderRaw(rec)
+		return
+	}
+	_, _ = fmt.Fprintln(p.out, string(data))
+}
+
+func (p *logPipeline) renderPretty(rec logRecord) {
+	source := p.paint(serviceColor(rec.Service), fmt.Sprintf("%-24s", rec.Source))
+	if !rec.Structured {
+		_, _ = fmt.Fprintf(p.out, "%s %s\n", source, rec.Raw)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(p.paint(colorGray, rec.Time.Local().Format("15:04:05.000")))
+	b.WriteString(" ")
+	b.WriteString(p.paint(levelColor(rec.Level), fmt.Sprintf("%-5s", strings.ToUpper(rec.Level))))
+	b.WriteString(" ")
+	b.WriteString(source)
+	b.WriteString(" ")
+	b.WriteString(rec.Message)
+	if rec.ReqID != "" {
+		b.WriteString(" ")
+		b.WriteString(p.paint(colorCyan, "req_id="))
+		b.WriteString(rec.ReqID)
+	}
+
+	keys := make([]string, 0, len(rec.Fields))
+	for key := range rec.Fields {
+		if !slices.Contains(werelogsKeys, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, err := json.Marshal(rec.Fields[key])
+		if err != nil {
+			continue
+		}
+		b.WriteString(" ")
+		b.WriteString(p.paint(colorGray, key+"="))
+		b.Write(value)
+	}
+
+	_, _ = fmt.Fprintln(p.out, b.String())
+}
+
+const (
+	colorRed     = "31"
+	colorGreen   = "32"
+	colorYellow  = "33"
+	colorBlue    = "34"
+	colorMagenta = "35"
+	colorCyan    = "36"
+	colorGray    = "90"
+)
+
+var serviceColors = []string{colorBlue, colorMagenta, colorCyan, colorGreen, colorYellow, "94", "95", "96"}
+
+func (p *logPipeline) paint(color, s string) string {
+	if !p.color {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+func serviceColor(service string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(service))
+	return serviceColors[h.Sum32()%uint32(len(serviceColors))]
+}
+
+func levelColor(level string) string {
+	switch strings.ToLower(level) {
+	case "trace", "debug":
+		return colorBlue
+	case "info":
+		return colorGreen
+	case "warn", "warning":
+		return colorYellow
+	case "error", "fatal":
+		return colorRed
+	}
+	return colorGray
+}
+
+// useColor reports whether colored output should be written to f.
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return isTerminal(f)
+}
+
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		structured bool
+		level      string
+		message    string
+		reqID      string
+		time       time.Time
+		fields     map[string]float64
+	}{
+		{
+			name: "raw line",
+			line: "Starting cloudserver",
+		},
+		{
+			name: "invalid json",
+			line: "{not json",
+		},
+		{
+			name:       "werelogs",
+			line:       `{"name":"S3","time":1700000000123,"level":"info","message":"processed request","req_id":"abc","httpCode":200}`,
+			structured: true,
+			level:      "info",
+			message:    "processed request",
+			reqID:      "abc",
+			time:       time.UnixMilli(1700000000123),
+			fields:     map[string]float64{"httpCode": 200},
+		},
+		{
+			name:       "compose timestamp",
+			line:       `2024-01-02T03:04:05.5Z {"level":"warn","message":"slow"}`,
+			structured: true,
+			level:      "warn",
+			message:    "slow",
+			time:       time.Date(2024, 1, 2, 3, 4, 5, 500000000, time.UTC),
+		},
+		{
+			name:       "nginx seconds",
+			line:       `{"time":1700000000.250,"level":"info","name":"nginx","message":"GET / 200","req_id":"","elapsed_s":0.042}`,
+			structured: true,
+			level:      "info",
+			message:    "GET / 200",
+			time:       time.UnixMilli(1700000000250),
+			fields:     map[string]float64{"elapsed_ms": 42},
+		},
+		{
+			name:       "rfc3339 time",
+			line:       `{"time":"2024-01-02T03:04:05Z","message":"hello"}`,
+			structured: true,
+			message:    "hello",
+			time:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := parseLogLine("cloudserver", "cloudserver", tt.line)
+			if rec.Raw != tt.line || rec.Service != "cloudserver" {
+				t.Errorf("unexpected raw record %+v", rec)
+			}
+			if rec.Structured != tt.structured || rec.Level != tt.level || rec.Message != tt.message || rec.ReqID != tt.reqID {
+				t.Errorf("unexpected record %+v", rec)
+			}
+			if !rec.Time.Equal(tt.time) {
+				t.Errorf("expected time %s, got %s", tt.time, rec.Time)
+			}
+			for key, value := range tt.fields {
+				if got, ok := rec.Fields[key].(float64); !ok || math.Abs(got-value) > 1e-9 {
+					t.Errorf("expected %s=%v, got %v", key, value, rec.Fields[key])
+				}
+			}
+			if _, ok := rec.Fields["elapsed_s"]; ok {
+				t.Error("expected elapsed_s to be converted to elapsed_ms")
+			}
+		})
+	}
+}
+
+func TestMatchesReqID(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		id       string
+		expected bool
+	}{
+		{name: "same id", line: `{"req_id":"abc"}`, id: "abc", expected: true},
+		{name: "nested ids", line: `{"req_id":"parent:abc:child"}`, id: "abc", expected: true},
+		{name: "other id", line: `{"req_id":"abcd"}`, id: "abc"},
+		{name: "no id", line: `{"message":"abc"}`, id: "abc"},
+		{name: "raw line contains id", line: "request abc failed", id: "abc", expected: true},
+		{name: "raw line without id", line: "request failed", id: "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseLogLine("s3", "s3", tt.line).MatchesReqID(tt.id); got != tt.expected {
+				t.Errorf("expected %t, got %t", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSplitComposeLine(t *testing.T) {
+	compose := composeFile{Services: map[string]composeService{
+		"cloudserver":                  {ContainerName: "workbench-s3"},
+		"setup-rate-limiting-svc-user": {ContainerName: "workbench-setup-cloudserver"},
+		"vault":                        {ContainerName: "workbench-vault"},
+	}}
+
+	tests := []struct {
+		line    string
+		service string
+		rest    string
+	}{
+		{line: "workbench-s3  | listening", service: "cloudserver", rest: "listening"},
+		{line: "workbench-setup-cloudserver | done", service: "setup-rate-limiting-svc-user", rest: "done"},
+		{line: "vault | ready", service: "vault", rest: "ready"},
+		{line: "workbench-unknown | x", service: "workbench-unknown", rest: "x"},
+		{line: "no prefix", service: "", rest: "no prefix"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			service, rest := splitComposeLine(compose, tt.line)
+			if service != tt.service || rest != tt.rest {
+				t.Errorf("expected %q, %q, got %q, %q", tt.service, tt.rest, service, rest)
+			}
+		})
+	}
+}
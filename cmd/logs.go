@@ -1,21 +1,62 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
 type LogsCmd struct {
-	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
-	Name   string `help:"Name of the environment to retrieve logs for. default: 'default'" short:"n"`
-	Follow bool   `help:"Follow log output." short:"f"`
+	EnvDir     string   `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name       string   `help:"Name of the environment to retrieve logs for. default: 'default'" short:"n"`
+	Services   []string `arg:"" optional:"" help:"Services or features (e.g. cloudserver, lifecycle) to show logs for. default: all"`
+	Follow     bool     `help:"Follow log output." short:"f"`
+	Since      string   `help:"Show logs since timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)."`
+	Until      string   `help:"Show logs before a timestamp (e.g. 2013-01-02T13:23:37Z) or relative (e.g. 42m)."`
+	Tail       string   `help:"Number of lines to show from the end of the logs of each service and file." default:"all"`
+	Timestamps bool     `help:"Show timestamps of container logs." short:"t"`
+	Grep       string   `help:"Only show lines matching a regular expression."`
+	NoFiles    bool     `help:"Don't include file based logs from the logs/ directory."`
+	ReqID      string   `help:"Only show log records of a request ID, across all services, in time order."`
+	Format     string   `help:"Output format. (pretty, raw, json)" enum:"pretty,raw,json" default:"pretty"`
+	NoColor    bool     `help:"Disable colored output."`
+}
+
+// featureServices maps feature names to the compose services they run.
+var featureServices = map[string][]string{
+	"scuba":                    {"scuba", "setup-scuba", "metadata-scuba"},
+	"bucket_notifications":     {"backbeat", "zookeeper", "kafka", "setup-kafka", "kafka-destination", "setup-kafka-destination", "notification-receiver"},
+	"cross_region_replication": {"backbeat", "redis", "zookeeper", "kafka", "setup-kafka"},
+	"utapi":                    {"utapi", "redis"},
+	"migration":                {"migration-tools", "redis"},
+	"access_logging":           {"fluentbit", "clickhouse-shard-1", "clickhouse-shard-2", "setup-clickhouse"},
+	"s3_frontend":              {"s3-frontend"},
+	"lifecycle":                {"backbeat", "redis", "zookeeper", "kafka", "setup-kafka"},
+	"rate_limiting":            {"setup-rate-limiting-svc-user", "redis"},
+}
+
+// serviceLogDirs maps compose services to the directory under logs/ where
+// they write log files that compose never sees.
+var serviceLogDirs = map[string]string{
+	"cloudserver":     "cloudserver",
+	"backbeat":        "backbeat",
+	"scuba":           "scuba",
+	"migration-tools": "migration-tools",
 }
 
 func (c *LogsCmd) Run() error {
@@ -29,29 +70,330 @@ func (c *LogsCmd) Run() error {
 		return err
 	}
 
-	args := []string{"logs"}
+	pipeline := &logPipeline{
+		out:    os.Stdout,
+		format: c.Format,
+		color:  !c.NoColor && useColor(os.Stdout),
+		reqID:  c.ReqID,
+		// A request's journey is easier to follow in time order
+		sorted: c.ReqID != "" && !c.Follow,
+	}
+	if c.Grep != "" {
+		pipeline.grep, err = regexp.Compile(c.Grep)
+		if err != nil {
+			return fmt.Errorf("invalid --grep expression: %w", err)
+		}
+	}
+
+	tail := -1
+	if c.Tail != "all" {
+		tail, err = strconv.Atoi(c.Tail)
+		if err != nil || tail < 0 {
+			return fmt.Errorf("invalid --tail value %q: must be a positive number or 'all'", c.Tail)
+		}
+	}
+
+	if c.Since != "" {
+		pipeline.since, err = parseLogTime(c.Since, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid --since value: %w", err)
+		}
+	}
 
-	if c.Follow {
-		args = append(args, "--follow")
+	if c.Until != "" {
+		pipeline.until, err = parseLogTime(c.Until, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid --until value: %w", err)
+		}
 	}
 
-	dockerComposeCmd := buildDockerComposeCommand(cfg, args...)
+	services := expandServices(c.Services)
 
-	fmt.Println(strings.Join(dockerComposeCmd, " "))
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, dockerComposeCmd[0], dockerComposeCmd[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = envPath
-	if err := cmd.Run(); err != nil {
+	var logFiles *logFileSet
+	if !c.NoFiles {
+		logFiles = newLogFileSet(filepath.Join(envPath, "logs"), serviceLogDirsFor(services), pipeline.since)
+	}
+
+	var wg sync.WaitGroup
+	var fileErr error
+	if logFiles != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fileErr = logFiles.Stream(ctx, pipeline, tail, c.Follow)
+		}()
+	}
+
+	opts := LogsOptions{
+		Services:   services,
+		Follow:     c.Follow,
+		Since:      c.Since,
+		Until:      c.Until,
+		Tail:       c.Tail,
+		Timestamps: c.Timestamps,
+	}
+	if err := orch.Logs(ctx, opts, pipeline.HandleContainer); err != nil {
 		if errors.Is(ctx.Err(), context.Canceled) {
 			return nil
 		}
 		return err
 	}
 
+	// Without --follow the file logs are complete once read, with it they
+	// stream until interrupted like the container logs.
+	wg.Wait()
+	if fileErr != nil && !errors.Is(fileErr, context.Canceled) {
+		return fileErr
+	}
+
+	pipeline.Flush()
+
+	return nil
+}
+
+// expandServices replaces feature names with the services they run.
+func expandServices(names []string) []string {
+	services := []string{}
+	for _, name := range names {
+		expanded, ok := featureServices[name]
+		if !ok {
+			expanded = []string{name}
+		}
+		for _, service := range expanded {
+			if !slices.Contains(services, service) {
+				services = append(services, service)
+			}
+		}
+	}
+	return services
+}
+
+// serviceLogDirsFor returns the log directories of the given services, or all
+// of them if no service is selected.
+func serviceLogDirsFor(services []string) []string {
+	dirs := []string{}
+	for service, dir := range serviceLogDirs {
+		if len(services) == 0 || slices.Contains(services, service) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// parseLogTime parses a timestamp or a duration relative to now, using the
+// same formats as docker compose logs.
+func parseLogTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("cannot parse %q as a timestamp or duration", value)
+}
+
+// logFileSet reads the log files written by the services to the logs/ directory.
+type logFileSet struct {
+	logsDir string
+	dirs    []string
+	since   time.Time
+	files   map[string]*logFile
+}
+
+type logFile struct {
+	path    string
+	service string
+	label   string
+	offset  int64
+	partial string
+}
+
+func newLogFileSet(logsDir string, dirs []string, since time.Time) *logFileSet {
+	return &logFileSet{
+		logsDir: logsDir,
+		dirs:    dirs,
+		since:   since,
+		files:   map[string]*logFile{},
+	}
+}
+
+// scan returns the log files that appeared since the last scan.
+func (s *logFileSet) scan() []*logFile {
+	found := []*logFile{}
+	for _, dir := range s.dirs {
+		entries, err := os.ReadDir(filepath.Join(s.logsDir, dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			path := filepath.Join(s.logsDir, dir, entry.Name())
+			if _, ok := s.files[path]; ok {
+				continue
+			}
+			lf := &logFile{path: path, service: dir, label: filepath.Join(dir, entry.Name())}
+			s.files[path] = lf
+			found = append(found, lf)
+		}
+	}
+	return found
+}
+
+// Stream prints the last tail lines of every log file (all of them if tail is
+// negative), then keeps printing new lines until ctx is done if follow is set.
+func (s *logFileSet) Stream(ctx context.Context, pipeline *logPipeline, tail int, follow bool) error {
+	for _, lf := range s.scan() {
+		info, err := os.Stat(lf.path)
+		if err != nil {
+			continue
+		}
+		lf.offset = info.Size()
+		if !s.since.IsZero() && info.ModTime().Before(s.since) {
+			continue
+		}
+
+		lines, err := readLastLines(lf.path, tail)
+		if err != nil {
+			log.Warn().Err(err).Str("file", lf.path).Msg("Failed to read log file")
+			continue
+		}
+		for _, line := range lines {
+			pipeline.HandleFile(lf.service, lf.label, line)
+		}
+	}
+
+	if !follow {
+		return nil
+	}
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		s.scan()
+		for _, lf := range s.files {
+			if err := lf.readNew(pipeline); err != nil {
+				log.Warn().Err(err).Str("file", lf.path).Msg("Failed to read log file")
+			}
+		}
+	}
+}
+
+// readNew prints the complete lines appended to the file since the last read.
+func (lf *logFile) readNew(pipeline *logPipeline) error {
+	info, err := os.Stat(lf.path)
+	if err != nil {
+		return nil
+	}
+
+	// The file was truncated or rotated, start over
+	if info.Size() < lf.offset {
+		lf.offset = 0
+		lf.partial = ""
+	}
+	if info.Size() == lf.offset {
+		return nil
+	}
+
+	f, err := os.Open(lf.path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Seek(lf.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	lf.offset += int64(len(data))
+
+	lines := strings.Split(lf.partial+string(data), "\n")
+	lf.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		pipeline.HandleFile(lf.service, lf.label, line)
+	}
 	return nil
 }
+
+// readLastLines returns the last n lines of a file, or all of them if n is negative.
+func readLastLines(path string, n int) ([]string, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	if n < 0 {
+		lines := []string{}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		return lines, scanner.Err()
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards in chunks until enough lines are buffered
+	const chunkSize = 64 * 1024
+	var buf []byte
+	offset := info.Size()
+	for offset > 0 && strings.Count(string(buf), "\n") <= n {
+		size := int64(chunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, nil
+}
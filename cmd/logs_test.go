@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLogsCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t)
+	orch.LogLines = map[string][]string{
+		"cloudserver": {"listening on 8000"},
+		"vault":       {"vault ready"},
+	}
+
+	cmd := LogsCmd{
+		EnvDir:   envDir,
+		Name:     DefaultEnvName,
+		Services: []string{"cloudserver"},
+		Tail:     "all",
+		NoFiles:  true,
+		Format:   "raw",
+		NoColor:  true,
+	}
+	out, err := captureStdout(t, cmd.Run)
+	if err != nil {
+		t.Fatalf("logs failed: %v", err)
+	}
+
+	assertCalls(t, orch, "logs cloudserver")
+	if !strings.Contains(out, "listening on 8000") {
+		t.Errorf("expected the cloudserver logs, got %q", out)
+	}
+	if strings.Contains(out, "vault ready") {
+		t.Errorf("expected only the cloudserver logs, got %q", out)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"os"
 
@@ -10,15 +11,31 @@ import (
 )
 
 var CLI struct {
-	LogLevel     string       `help:"Set the log level." enum:"trace,debug,info,warn,error" default:"info"`
-	LogFormat    string       `enum:"json,text" default:"text" help:"Set the log format. (json, text)"`
-	TemplatesDir string       `help:"Directory containing config templates. Overrides embedded templates." default:""`
-	CreateEnv    CreateEnvCmd `cmd:"" help:"Create a new S3C workbench environment."`
-	Up           UpCmd        `cmd:"" help:"Start an S3C workbench environment."`
-	Configure    ConfigureCmd `cmd:"" help:"Generate configuration files from templates."`
-	Destroy      DestroyCmd   `cmd:"" help:"Destroy an S3C workbench environment."`
-	Down         DownCmd      `cmd:"" help:"Stop an S3C workbench environment."`
-	Logs         LogsCmd      `cmd:"" help:"View logs of an S3C workbench environment."`
+	LogLevel        string           `help:"Set the log level." enum:"trace,debug,info,warn,error" default:"info"`
+	LogFormat       string           `enum:"json,text" default:"text" help:"Set the log format. (json, text)"`
+	TemplatesDir    string           `help:"Directory containing config templates. Overrides embedded templates." default:""`
+	Orchestrator    string           `help:"How containers are run: compose shells out to docker compose, compose-engine creates them with compose and manages them through the Docker Engine API." enum:"compose,compose-engine" default:"compose"`
+	ContainerEngine string           `help:"Container engine running the environment, detected by default." enum:"auto,docker,docker-compose,podman" default:"auto" env:"WORKBENCH_CONTAINER_ENGINE"`
+	CreateEnv       CreateEnvCmd     `cmd:"" help:"Create a new S3C workbench environment."`
+	Up              UpCmd            `cmd:"" help:"Start an S3C workbench environment."`
+	Configure       ConfigureCmd     `cmd:"" help:"Generate configuration files from templates."`
+	Destroy         DestroyCmd       `cmd:"" help:"Destroy an S3C workbench environment."`
+	Down            DownCmd          `cmd:"" help:"Stop an S3C workbench environment."`
+	Status          StatusCmd        `cmd:"" help:"Show the containers and debug ports of an S3C workbench environment."`
+	Doctor          DoctorCmd        `cmd:"" help:"Check that the host can run an S3C workbench environment."`
+	Restart         RestartCmd       `cmd:"" help:"Re-render a component's configuration and restart its containers."`
+	Reconfigure     ReconfigureCmd   `cmd:"" help:"Re-render all configuration and restart the services whose files changed."`
+	Exec            ExecCmd          `cmd:"" help:"Run a command in a service container."`
+	Shell           ShellCmd         `cmd:"" help:"Open a shell in a service container with its tooling preconfigured."`
+	Env             EnvCmd           `cmd:"" help:"Export and import S3C workbench environments."`
+	Lock            LockCmd          `cmd:"" help:"Pin the images of an S3C workbench environment to digests."`
+	Images          ImagesCmd        `cmd:"" help:"Save and load the images of an S3C workbench environment."`
+	Notifications   NotificationsCmd `cmd:"" help:"Inspect the bucket notifications of an S3C workbench environment."`
+	Kafka           KafkaCmd         `cmd:"" help:"Inspect the kafka topics, consumer groups and messages of an S3C workbench environment."`
+	Lifecycle       LifecycleCmd     `cmd:"" help:"Run the lifecycle processes of an S3C workbench environment on demand."`
+	Snapshot        SnapshotCmd      `cmd:"" help:"Create, restore, list and delete snapshots of an S3C workbench environment."`
+	Logs            LogsCmd          `cmd:"" help:"View logs of an S3C workbench environment."`
+	SupportBundle   SupportBundleCmd `cmd:"" help:"Collect diagnostics of an S3C workbench environment into a tarball."`
 }
 
 func main() {
@@ -59,5 +76,9 @@ func main() {
 	log.Logger = zerolog.New(writer).Level(logLevel).With().Timestamp().Logger()
 
 	err := cmd.Run()
+	var exitErr exitCodeError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.code)
+	}
 	cmd.FatalIfErrorf(err)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const connectionManifestFile = "connection.json"
+
+// testaccount credentials are fixed in templates/vault/create-management-account.sh
+const (
+	testAccountAccessKey = "WBTKACCESSI9O3YKIRQ0"
+	testAccountSecretKey = "ICxmNTBbOqijy4rMq/MOP1EPlTMqfsEBLjROcAbN"
+	defaultRegion        = "us-east-1"
+)
+
+// ConnectionManifest describes how to reach a running environment.
+// It is written to connection.json in the environment directory so scripts
+// and CI steps don't have to hardcode endpoints or credentials.
+type ConnectionManifest struct {
+	Environment string   `json:"environment"`
+	EnvPath     string   `json:"env_path"`
+	S3Endpoint  string   `json:"s3_endpoint"`
+	IAMEndpoint string   `json:"iam_endpoint"`
+	STSEndpoint string   `json:"sts_endpoint"`
+	Region      string   `json:"region"`
+	AccessKey   string   `json:"access_key"`
+	SecretKey   string   `json:"secret_key"`
+	Features    []string `json:"features"`
+}
+
+func buildConnectionManifest(cfg EnvironmentConfig, envName, envPath string) (ConnectionManifest, error) {
+	absPath, err := filepath.Abs(envPath)
+	if err != nil {
+		return ConnectionManifest{}, fmt.Errorf("failed to resolve environment path: %w", err)
+	}
+
+	s3Endpoint := "http://127.0.0.1:8000"
+	if cfg.Features.S3Frontend.Enabled {
+		s3Endpoint = fmt.Sprintf("http://127.0.0.1:%d", cfg.Nginx.HTTPPort)
+	}
+
+	return ConnectionManifest{
+		Environment: envName,
+		EnvPath:     absPath,
+		S3Endpoint:  s3Endpoint,
+		IAMEndpoint: "http://127.0.0.1:8600",
+		STSEndpoint: "http://127.0.0.1:8800",
+		Region:      defaultRegion,
+		AccessKey:   testAccountAccessKey,
+		SecretKey:   testAccountSecretKey,
+		Features:    enabledFeatures(cfg),
+	}, nil
+}
+
+func writeConnectionManifest(cfg EnvironmentConfig, envName, envPath string) error {
+	manifest, err := buildConnectionManifest(cfg, envName, envPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to encode connection manifest: %w", err)
+	}
+
+	manifestPath := filepath.Join(envPath, connectionManifestFile)
+	if err := os.WriteFile(manifestPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	notificationDestinationKafka   = "kafka"
+	notificationDestinationWebhook = "webhook"
+
+	notificationAuthNone        = "none"
+	notificationAuthBasic       = "basic"
+	notificationAuthScramSHA256 = "scram-sha-256"
+	notificationAuthScramSHA512 = "scram-sha-512"
+	notificationAuthSSL         = "ssl"
+
+	// notificationDestinationPort is the kafka-destination listener using
+	// destinationAuth.
+	notificationDestinationPort = 9094
+	// notificationInternalPort is the plaintext listener of kafka-destination
+	// used between brokers and by setup-kafka-destination, on the loopback.
+	notificationInternalPort = 9095
+	// notificationExtraPortBase is the first port of the kafka-destination
+	// listeners of the other auth types.
+	notificationExtraPortBase = 9096
+)
+
+// notificationResourcePattern keeps resources usable in supervisord program
+// names, file names and URL paths.
+var notificationResourcePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+var notificationAuthTypes = []string{
+	notificationAuthNone,
+	notificationAuthBasic,
+	notificationAuthScramSHA256,
+	notificationAuthScramSHA512,
+	notificationAuthSSL,
+}
+
+func (a NotificationAuthConfig) validate() error {
+	if !slices.Contains(notificationAuthTypes, a.Type) {
+		return fmt.Errorf("unknown auth type %q (valid types: %s)", a.Type, strings.Join(notificationAuthTypes, ", "))
+	}
+	if a.Mechanism() == "" {
+		return nil
+	}
+	if a.Username == "" || a.Password == "" {
+		return fmt.Errorf("%s auth needs a username and a password", a.Type)
+	}
+	// SCRAM credentials are passed to kafka-configs.sh as user:password
+	if a.Type != notificationAuthBasic && strings.ContainsAny(a.Username+a.Password, ": \t\n,=[]") {
+		return fmt.Errorf("%s usernames and passwords can't contain spaces or any of :,=[]", a.Type)
+	}
+	return nil
+}
+
+// Mechanism is the SASL mechanism of the auth, empty for none and ssl.
+func (a NotificationAuthConfig) Mechanism() string {
+	switch a.Type {
+	case notificationAuthBasic:
+		return "PLAIN"
+	case notificationAuthScramSHA256, notificationAuthScramSHA512:
+		return strings.ToUpper(a.Type)
+	}
+	return ""
+}
+
+// resolve validates the destinations and fills in their defaults. Webhook
+// destinations without a URL send their events to the notification receiver.
+func (c *BucketNotificationsFeatureConfig) resolve(receiverPort uint16) error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if err := c.DestinationAuth.validate(); err != nil {
+		return fmt.Errorf("invalid destinationAuth: %w", err)
+	}
+
+	if len(c.Destinations) == 0 {
+		c.Destinations = []NotificationDestination{{Resource: "destination1", Type: notificationDestinationKafka}}
+	}
+
+	extraPorts := map[string]int{}
+	for i := range c.Destinations {
+		d := &c.Destinations[i]
+		if !notificationResourcePattern.MatchString(d.Resource) {
+			return fmt.Errorf("destination %d has an invalid resource %q, use letters, digits, - and _", i, d.Resource)
+		}
+		if slices.ContainsFunc(c.Destinations[:i], func(o NotificationDestination) bool { return o.Resource == d.Resource }) {
+			return fmt.Errorf("destination %s is declared twice", d.Resource)
+		}
+
+		if d.Type == "" {
+			d.Type = notificationDestinationKafka
+		}
+		switch d.Type {
+		case notificationDestinationKafka:
+			if d.Topic == "" {
+				d.Topic = notificationsTopic
+			}
+			if d.Host == "" {
+				if d.Auth.Type == "" {
+					d.Auth = c.DestinationAuth
+				}
+				d.Port = notificationDestinationPort
+				if d.Auth.Type != c.DestinationAuth.Type {
+					if _, ok := extraPorts[d.Auth.Type]; !ok {
+						extraPorts[d.Auth.Type] = notificationExtraPortBase + len(extraPorts)
+					}
+					d.Port = extraPorts[d.Auth.Type]
+				}
+			} else if _, _, err := net.SplitHostPort(d.Host); err != nil {
+				return fmt.Errorf("destination %s has an invalid host %q, use host:port", d.Resource, d.Host)
+			} else if d.Auth.Type == notificationAuthSSL {
+				return fmt.Errorf("destination %s uses ssl auth, which needs the certificates of kafka-destination", d.Resource)
+			}
+		case notificationDestinationWebhook:
+			if d.URL == "" {
+				d.URL = fmt.Sprintf("http://127.0.0.1:%d/%s", receiverPort, d.Resource)
+			} else if u, err := url.Parse(d.URL); err != nil || u.Host == "" {
+				return fmt.Errorf("destination %s has an invalid url %q", d.Resource, d.URL)
+			}
+			if d.Auth.Type != "" && d.Auth.Type != notificationAuthNone && d.Auth.Type != notificationAuthBasic {
+				return fmt.Errorf("webhook destination %s only supports %s and %s auth", d.Resource, notificationAuthNone, notificationAuthBasic)
+			}
+		default:
+			return fmt.Errorf("destination %s has an unknown type %q (valid types: %s, %s)",
+				d.Resource, d.Type, notificationDestinationKafka, notificationDestinationWebhook)
+		}
+
+		if d.Auth.Type == "" {
+			d.Auth.Type = notificationAuthNone
+		}
+		if err := d.Auth.validate(); err != nil {
+			return fmt.Errorf("invalid auth of destination %s: %w", d.Resource, err)
+		}
+
+		d.Offset = notificationProcessorOffset(i)
+	}
+
+	_, err := c.listeners()
+	return err
+}
+
+// notificationProcessorOffset returns the inspector port offset of the
+// processor of the i-th destination. The first one keeps the offset of
+// notification-processor, the others come after the other backbeat programs.
+func notificationProcessorOffset(i int) int {
+	if i == 0 {
+		return slices.IndexFunc(backbeatPrograms, func(p backbeatProgram) bool { return p.Name == "notification-processor" })
+	}
+	return backbeatDefaultPrograms() + i - 1
+}
+
+// Address is the host:port of a kafka destination, or of the URL of a
+// webhook destination.
+func (d NotificationDestination) Address() string {
+	switch {
+	case d.Type == notificationDestinationWebhook:
+		u, err := url.Parse(d.URL)
+		if err != nil {
+			return ""
+		}
+		if u.Port() != "" {
+			return u.Host
+		}
+		if u.Scheme == "https" {
+			return net.JoinHostPort(u.Hostname(), "443")
+		}
+		return net.JoinHostPort(u.Hostname(), "80")
+	case d.Host != "":
+		return d.Host
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(d.Port))
+}
+
+// HostName is the host of Address.
+func (d NotificationDestination) HostName() string {
+	host, _, _ := net.SplitHostPort(d.Address())
+	return host
+}
+
+// PortNumber is the port of Address.
+func (d NotificationDestination) PortNumber() int {
+	_, port, _ := net.SplitHostPort(d.Address())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// local reports whether a destination uses kafka-destination.
+func (d NotificationDestination) local() bool {
+	return d.Type == notificationDestinationKafka && d.Host == ""
+}
+
+// NotificationListener is a listener of kafka-destination.
+type NotificationListener struct {
+	// Name is the listener name of the broker configuration, e.g. BASIC
+	Name     string
+	Protocol string
+	Port     int
+	AuthType string
+	// Mechanism is the SASL mechanism of the listener, if any
+	Mechanism string
+	// Users maps the usernames of SASL auth to their passwords
+	Users map[string]string
+}
+
+// Listeners returns the listeners of kafka-destination, the one of
+// destinationAuth first.
+func (c BucketNotificationsFeatureConfig) Listeners() []NotificationListener {
+	listeners, _ := c.listeners()
+	return listeners
+}
+
+func (c BucketNotificationsFeatureConfig) listeners() ([]NotificationListener, error) {
+	listeners := []NotificationListener{newNotificationListener(c.DestinationAuth.Type, notificationDestinationPort)}
+	auths := []NotificationAuthConfig{c.DestinationAuth}
+	ports := []int{notificationDestinationPort}
+	for _, d := range c.Destinations {
+		if d.local() {
+			auths = append(auths, d.Auth)
+			ports = append(ports, d.Port)
+		}
+	}
+
+	for i, auth := range auths {
+		idx := slices.IndexFunc(listeners, func(l NotificationListener) bool { return l.Port == ports[i] })
+		if idx < 0 {
+			listeners = append(listeners, newNotificationListener(auth.Type, ports[i]))
+			idx = len(listeners) - 1
+		}
+		if auth.Mechanism() == "" {
+			continue
+		}
+		if password, ok := listeners[idx].Users[auth.Username]; ok && password != auth.Password {
+			return nil, fmt.Errorf("user %s has different passwords on kafka-destination", auth.Username)
+		}
+		listeners[idx].Users[auth.Username] = auth.Password
+	}
+	return listeners, nil
+}
+
+func newNotificationListener(authType string, port int) NotificationListener {
+	mechanism := NotificationAuthConfig{Type: authType}.Mechanism()
+	protocol := "PLAINTEXT"
+	switch {
+	case mechanism != "":
+		protocol = "SASL_PLAINTEXT"
+	case authType == notificationAuthSSL:
+		protocol = "SSL"
+	}
+	return NotificationListener{
+		Name:      strings.ToUpper(strings.ReplaceAll(authType, "-", "_")),
+		Protocol:  protocol,
+		Port:      port,
+		AuthType:  authType,
+		Mechanism: mechanism,
+		Users:     map[string]string{},
+	}
+}
+
+// usesSSL reports whether a listener of kafka-destination uses mutual TLS.
+func (c BucketNotificationsFeatureConfig) usesSSL() bool {
+	return c.Enabled && slices.ContainsFunc(c.Listeners(), func(l NotificationListener) bool { return l.AuthType == notificationAuthSSL })
+}
+
+// scramUsers returns the SCRAM credentials created by setup-kafka-destination
+// as mechanism:username:password.
+func (c BucketNotificationsFeatureConfig) scramUsers() []string {
+	var users []string
+	for _, l := range c.Listeners() {
+		if l.AuthType != notificationAuthScramSHA256 && l.AuthType != notificationAuthScramSHA512 {
+			continue
+		}
+		for _, user := range slices.Sorted(maps.Keys(l.Users)) {
+			users = append(users, fmt.Sprintf("%s:%s:%s", l.Mechanism, user, l.Users[user]))
+		}
+	}
+	return users
+}
+
+// localTopics returns the topics of the destinations using kafka-destination.
+func (c BucketNotificationsFeatureConfig) localTopics() []string {
+	var topics []string
+	for _, d := range c.Destinations {
+		if d.local() && !slices.Contains(topics, d.Topic) {
+			topics = append(topics, d.Topic)
+		}
+	}
+	return topics
+}
+
+// receiverEnabled reports whether a webhook destination sends its events to
+// the notification receiver.
+func (cfg EnvironmentConfig) receiverEnabled() bool {
+	if !cfg.Features.BucketNotifications.Enabled {
+		return false
+	}
+	prefix := fmt.Sprintf("http://127.0.0.1:%d/", cfg.NotificationReceiver.Port)
+	return slices.ContainsFunc(cfg.Features.BucketNotifications.Destinations, func(d NotificationDestination) bool {
+		return d.Type == notificationDestinationWebhook && strings.HasPrefix(d.URL, prefix)
+	})
+}
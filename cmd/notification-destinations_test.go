@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBucketNotificationsResolve(t *testing.T) {
+	none := NotificationAuthConfig{Type: notificationAuthNone}
+	basic := NotificationAuthConfig{Type: notificationAuthBasic, Username: "client", Password: "client123"}
+	// The first destination keeps the inspector port of notification-processor
+	processorOffset := notificationProcessorOffset(0)
+
+	tests := []struct {
+		name         string
+		config       BucketNotificationsFeatureConfig
+		expected     []NotificationDestination
+		errorMessage string
+	}{
+		{
+			name:   "default destination",
+			config: BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none},
+			expected: []NotificationDestination{
+				{Resource: "destination1", Type: "kafka", Topic: "notifications", Auth: none, Port: 9094, Offset: processorOffset},
+			},
+		},
+		{
+			name: "listener per auth type",
+			config: BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{
+				{Resource: "destination1"},
+				{Resource: "secured", Topic: "secured", Auth: basic},
+				{Resource: "secured2", Auth: basic},
+				{Resource: "external", Host: "kafka.example.com:9092", Topic: "s3-events"},
+			}},
+			expected: []NotificationDestination{
+				{Resource: "destination1", Type: "kafka", Topic: "notifications", Auth: none, Port: 9094, Offset: processorOffset},
+				{Resource: "secured", Type: "kafka", Topic: "secured", Auth: basic, Port: 9096, Offset: backbeatDefaultPrograms()},
+				{Resource: "secured2", Type: "kafka", Topic: "notifications", Auth: basic, Port: 9096, Offset: backbeatDefaultPrograms() + 1},
+				{Resource: "external", Type: "kafka", Host: "kafka.example.com:9092", Topic: "s3-events", Auth: none, Offset: backbeatDefaultPrograms() + 2},
+			},
+		},
+		{
+			name: "webhook to the receiver",
+			config: BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{
+				{Resource: "hook", Type: "webhook"},
+			}},
+			expected: []NotificationDestination{
+				{Resource: "hook", Type: "webhook", URL: "http://127.0.0.1:8180/hook", Auth: none, Offset: processorOffset},
+			},
+		},
+		{
+			name:         "invalid resource",
+			config:       BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{{Resource: "a/b"}}},
+			errorMessage: "invalid resource",
+		},
+		{
+			name:         "duplicate resource",
+			config:       BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{{Resource: "a"}, {Resource: "a"}}},
+			errorMessage: "declared twice",
+		},
+		{
+			name:         "host without port",
+			config:       BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{{Resource: "a", Host: "kafka"}}},
+			errorMessage: "invalid host",
+		},
+		{
+			name: "webhook with scram",
+			config: BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{
+				{Resource: "hook", Type: "webhook", Auth: NotificationAuthConfig{Type: notificationAuthScramSHA256, Username: "u", Password: "p"}},
+			}},
+			errorMessage: "only supports",
+		},
+		{
+			name:         "unknown type",
+			config:       BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: none, Destinations: []NotificationDestination{{Resource: "a", Type: "sqs"}}},
+			errorMessage: "unknown type",
+		},
+		{
+			name:         "basic without password",
+			config:       BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "u"}},
+			errorMessage: "needs a username and a password",
+		},
+		{
+			name: "user with two passwords",
+			config: BucketNotificationsFeatureConfig{Enabled: true, DestinationAuth: basic, Destinations: []NotificationDestination{
+				{Resource: "destination1"},
+				{Resource: "other", Auth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "client", Password: "other"}},
+			}},
+			errorMessage: "different passwords",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.resolve(8180)
+			if tt.errorMessage != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMessage) {
+					t.Fatalf("expected an error containing %q, got %v", tt.errorMessage, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.config.Destinations, tt.expected) {
+				t.Errorf("unexpected destinations\n got: %+v\nwant: %+v", tt.config.Destinations, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNotificationListeners(t *testing.T) {
+	notifications := BucketNotificationsFeatureConfig{
+		Enabled:         true,
+		DestinationAuth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "admin", Password: "admin123"},
+		Destinations: []NotificationDestination{
+			{Resource: "destination1"},
+			{Resource: "open", Auth: NotificationAuthConfig{Type: notificationAuthNone}},
+			{Resource: "client", Auth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "client", Password: "client123"}},
+		},
+	}
+	if err := notifications.resolve(8180); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []NotificationListener{
+		{Name: "BASIC", Protocol: "SASL_PLAINTEXT", Port: 9094, AuthType: "basic", Mechanism: "PLAIN",
+			Users: map[string]string{"admin": "admin123", "client": "client123"}},
+		{Name: "NONE", Protocol: "PLAINTEXT", Port: 9096, AuthType: "none", Users: map[string]string{}},
+	}
+	if listeners := notifications.Listeners(); !reflect.DeepEqual(listeners, expected) {
+		t.Errorf("unexpected listeners\n got: %+v\nwant: %+v", listeners, expected)
+	}
+}
+
+func TestNotificationDestinationsConfig(t *testing.T) {
+	values := filepath.Join(t.TempDir(), "values.yaml")
+	data := `features:
+  bucket_notifications:
+    enabled: true
+    destinations:
+      - resource: destination1
+      - resource: hook
+        type: webhook
+        url: 'http://example.com:8080/a"b?x=1&y=\2'
+        auth:
+          type: basic
+          username: u"ser
+          password: p\ss
+      - resource: receiver
+        type: webhook
+`
+	if err := os.WriteFile(values, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, envPath := newTestEnv(t)
+	if err := writeValuesOverrides(envPath, []string{values}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := configureEnv(cfg, envPath); err != nil {
+		t.Fatal(err)
+	}
+
+	type destination struct {
+		Resource string         `json:"resource"`
+		Host     string         `json:"host"`
+		URL      string         `json:"url"`
+		Auth     map[string]any `json:"auth"`
+	}
+	var cloudserver struct {
+		Destinations []destination `json:"bucketNotificationDestinations"`
+	}
+	readJSON(t, filepath.Join(envPath, "config", "cloudserver", "config.json"), &cloudserver)
+	expectedAuth := []map[string]any{
+		{},
+		{"type": "basic", "username": `u"ser`, "password": `p\ss`},
+		nil,
+	}
+	for i, d := range cloudserver.Destinations {
+		if !reflect.DeepEqual(d.Auth, expectedAuth[i]) {
+			t.Errorf("unexpected cloudserver auth of %s: %v", d.Resource, d.Auth)
+		}
+	}
+
+	var backbeat struct {
+		Extensions struct {
+			Notification struct {
+				Destinations []destination `json:"destinations"`
+			} `json:"notification"`
+		} `json:"extensions"`
+	}
+	readJSON(t, filepath.Join(envPath, "config", "backbeat", "config.notification.json"), &backbeat)
+	if url := backbeat.Extensions.Notification.Destinations[1].URL; url != `http://example.com:8080/a"b?x=1&y=\2` {
+		t.Errorf("unexpected backbeat webhook url %q", url)
+	}
+
+	compose, err := loadComposeFile(envPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := compose.Services["notification-receiver"]; !ok {
+		t.Error("expected the notification receiver to be declared with a webhook to it")
+	}
+}
+
+func readJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid JSON in %s: %v", path, err)
+	}
+}
+
+func TestNotificationReceiverOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		declared bool
+	}{
+		{name: "receiver", url: "", declared: true},
+		{name: "external webhook", url: "https://hooks.example.com/s3", declared: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg EnvironmentConfig
+			cfg.NotificationReceiver.Port = 8180
+			cfg.Features.BucketNotifications = BucketNotificationsFeatureConfig{
+				Enabled:         true,
+				DestinationAuth: NotificationAuthConfig{Type: notificationAuthNone},
+				Destinations:    []NotificationDestination{{Resource: "hook", Type: notificationDestinationWebhook, URL: tt.url}},
+			}
+			if err := cfg.Features.BucketNotifications.resolve(cfg.NotificationReceiver.Port); err != nil {
+				t.Fatal(err)
+			}
+
+			override := composeOverride{Services: map[string]*composeOverrideService{}}
+			addNotificationOverrides(cfg, &override)
+			if _, ok := override.Services["notification-receiver"]; ok != tt.declared {
+				t.Errorf("expected notification-receiver declared %t, got %t", tt.declared, ok)
+			}
+		})
+	}
+}
+
+func TestScramUsers(t *testing.T) {
+	notifications := BucketNotificationsFeatureConfig{
+		Enabled:         true,
+		DestinationAuth: NotificationAuthConfig{Type: notificationAuthScramSHA512, Username: "admin", Password: "admin123"},
+		Destinations: []NotificationDestination{
+			{Resource: "destination1"},
+			{Resource: "app", Auth: NotificationAuthConfig{Type: notificationAuthScramSHA512, Username: "app", Password: "app123"}},
+			{Resource: "legacy", Auth: NotificationAuthConfig{Type: notificationAuthScramSHA256, Username: "legacy", Password: "legacy123"}},
+			{Resource: "plain", Auth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "plain", Password: "plain123"}},
+			{Resource: "external", Host: "kafka.example.com:9092", Auth: NotificationAuthConfig{Type: notificationAuthScramSHA256, Username: "ext", Password: "ext123"}},
+		},
+	}
+	if err := notifications.resolve(8180); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users of external brokers and of other mechanisms are not created
+	expected := []string{"SCRAM-SHA-512:admin:admin123", "SCRAM-SHA-512:app:app123", "SCRAM-SHA-256:legacy:legacy123"}
+	if users := notifications.scramUsers(); !reflect.DeepEqual(users, expected) {
+		t.Errorf("got %q, want %q", users, expected)
+	}
+}
+
+func TestNotificationTLSOverride(t *testing.T) {
+	tests := []struct {
+		name    string
+		auth    string
+		mounted bool
+	}{
+		{name: "ssl destination", auth: notificationAuthSSL, mounted: true},
+		{name: "scram destination", auth: notificationAuthScramSHA256, mounted: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cfg EnvironmentConfig
+			cfg.Features.BucketNotifications = BucketNotificationsFeatureConfig{
+				Enabled:         true,
+				DestinationAuth: NotificationAuthConfig{Type: notificationAuthNone},
+				Destinations:    []NotificationDestination{{Resource: "secured", Auth: NotificationAuthConfig{Type: tt.auth, Username: "u", Password: "p"}}},
+			}
+			if err := cfg.Features.BucketNotifications.resolve(8180); err != nil {
+				t.Fatal(err)
+			}
+
+			override := composeOverride{Services: map[string]*composeOverrideService{}}
+			addNotificationOverrides(cfg, &override)
+			for service, mount := range map[string]string{
+				"kafka-destination": "./config/kafka/tls:/opt/kafka/config/tls:ro",
+				"backbeat":          "./config/kafka/tls:/conf/notification-tls:ro",
+			} {
+				var volumes []string
+				if svc, ok := override.Services[service]; ok {
+					volumes = svc.Volumes
+				}
+				if slices.Contains(volumes, mount) != tt.mounted {
+					t.Errorf("expected %s mounted on %s: %t, got volumes %q", mount, service, tt.mounted, volumes)
+				}
+			}
+		})
+	}
+}
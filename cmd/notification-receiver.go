@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type NotificationsReceivedCmd struct {
+	EnvDir      string        `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name        string        `help:"Name of the environment. default: 'default'" short:"n"`
+	Destination []string      `help:"Only print the events delivered to this webhook destination. Can be repeated."`
+	Bucket      []string      `help:"Only print the events of this bucket. Can be repeated." short:"b"`
+	Event       []string      `help:"Only print events of this type, * matches a part, e.g. s3:ObjectCreated:*. Can be repeated." short:"e"`
+	JSON        bool          `help:"Print the event records as JSON, one per line."`
+	Follow      bool          `help:"Keep printing events as they are received." short:"f"`
+	New         bool          `help:"Only print the events received after the command started."`
+	Expect      bool          `help:"Exit after the first matching event, fail when none arrives within --timeout."`
+	Timeout     time.Duration `help:"How long --expect waits for a matching event." default:"30s"`
+}
+
+// notificationReceiverEvents is written by the notification receiver below
+// its logs directory.
+const notificationReceiverEvents = "events.jsonl"
+
+// receivedNotification is a request recorded by the notification receiver.
+type receivedNotification struct {
+	ReceivedAt  string          `json:"receivedAt"`
+	Destination string          `json:"destination"`
+	Body        json.RawMessage `json:"body"`
+}
+
+func (c *NotificationsReceivedCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+	if !cfg.receiverEnabled() {
+		return errors.New("no destination uses the notification receiver, add a webhook destination without url to features.bucket_notifications.destinations")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	if c.Expect {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	path := filepath.Join(envPath, "logs", "notification-receiver", notificationReceiverEvents)
+	var offset int64
+	if c.New {
+		if info, err := os.Stat(path); err == nil {
+			offset = info.Size()
+		}
+	}
+
+	filter := notificationFilter{buckets: c.Bucket, events: c.Event, json: c.JSON}
+	for {
+		var matched bool
+		offset, matched, err = c.printReceived(path, offset, filter)
+		if err != nil {
+			return err
+		}
+		if matched && c.Expect {
+			return nil
+		}
+		if !c.Follow && !c.Expect {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if !c.Expect {
+				return nil
+			}
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("no matching notification within %s", c.Timeout)
+			}
+			return errors.New("no matching notification")
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// printReceived prints the matching events recorded after offset, and
+// returns the offset of the first incomplete line. With --expect it stops at
+// the first matching event.
+func (c *NotificationsReceivedCmd) printReceived(path string, offset int64, filter notificationFilter) (int64, bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Nothing was received yet
+		return offset, false, nil
+	}
+	if err != nil {
+		return offset, false, fmt.Errorf("failed to open received notifications: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return offset, false, fmt.Errorf("failed to read received notifications: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return offset, false, fmt.Errorf("failed to read received notifications: %w", err)
+	}
+
+	for {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return offset, false, nil
+		}
+		line := data[:end]
+		data = data[end+1:]
+		offset += int64(end + 1)
+
+		var record receivedNotification
+		if err := json.Unmarshal(line, &record); err != nil {
+			log.Warn().Err(err).Msg("Ignoring invalid received notification")
+			continue
+		}
+		if len(c.Destination) > 0 && !slices.Contains(c.Destination, record.Destination) {
+			continue
+		}
+
+		events, err := parseNotification(record.Body)
+		if err != nil {
+			log.Warn().Err(err).Str("destination", record.Destination).Msg("Ignoring request that is not an S3 event")
+			continue
+		}
+		for _, event := range events {
+			if !filter.matches(event) {
+				continue
+			}
+			filter.print(event, " destination="+record.Destination)
+			if c.Expect {
+				return offset, true, nil
+			}
+		}
+	}
+}
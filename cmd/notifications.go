@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type NotificationsCmd struct {
+	Tail     NotificationsTailCmd     `cmd:"" help:"Print the bucket notifications received by the destination."`
+	Received NotificationsReceivedCmd `cmd:"" help:"Print the bucket notifications recorded by the notification receiver."`
+}
+
+type NotificationsTailCmd struct {
+	EnvDir        string        `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name          string        `help:"Name of the environment. default: 'default'" short:"n"`
+	Bucket        []string      `help:"Only print the events of this bucket. Can be repeated." short:"b"`
+	Event         []string      `help:"Only print events of this type, * matches a part, e.g. s3:ObjectCreated:*. Can be repeated." short:"e"`
+	Topic         string        `help:"Topic to consume, with the address and auth of the kafka destination using it." default:"notifications"`
+	FromBeginning bool          `help:"Also print the events received before the command started."`
+	JSON          bool          `help:"Print the event records as JSON, one per line."`
+	Expect        bool          `help:"Exit after the first matching event, fail when none arrives within --timeout."`
+	Timeout       time.Duration `help:"How long --expect waits for a matching event." default:"30s"`
+}
+
+const (
+	notificationsService   = "kafka-destination"
+	notificationsBootstrap = "127.0.0.1:9094"
+	notificationsTopic     = "notifications"
+	// notificationsConfig holds the client settings of destinationAuth
+	notificationsConfig = "/opt/kafka/config/config.properties"
+)
+
+// notificationsReady is printed by notificationConsumer once the
+// consumers start from the end offsets of the topic.
+const notificationsReady = "workbench:notifications-ready"
+
+// notificationConsumer runs a console consumer per partition of the topic
+// from its end offset, read before printing notificationsReady, so that no
+// event sent after it is missed while the consumers join. The consumers run
+// until stdin is closed, as docker doesn't stop exec'd processes when the
+// client goes away. The client properties are passed as an argument, written
+// to a temporary file for the kafka tools.
+const notificationConsumer = `set -o pipefail
+bootstrap=$1 properties=$2 topic=$3 from_beginning=$4
+exec 3<&0
+config=$(mktemp) || exit 1
+trap 'rm -f "$config"' EXIT
+printf '%s\n' "$properties" >"$config"
+if command -v kafka-get-offsets.sh >/dev/null; then
+    get_offsets=(kafka-get-offsets.sh)
+else
+    # kafka-get-offsets.sh comes with kafka 3.0
+    get_offsets=(kafka-run-class.sh kafka.tools.GetOffsetShell)
+fi
+offsets=$("${get_offsets[@]}" --bootstrap-server "$bootstrap" --command-config "$config" --topic "$topic") || exit 1
+if [[ -z "$offsets" ]]; then
+    echo "topic $topic not found" >&2
+    exit 1
+fi
+pids=()
+while IFS=: read -r _ partition end; do
+    offset=$end
+    if [[ -n "$from_beginning" ]]; then
+        offset=earliest
+    fi
+    kafka-console-consumer.sh --bootstrap-server "$bootstrap" --consumer.config "$config" --topic "$topic" \
+        --partition "$partition" --offset "$offset" </dev/null &
+    pids+=($!)
+done <<<"$offsets"
+echo "` + notificationsReady + `"
+(read -r _ <&3; kill "${pids[@]}" 2>/dev/null) &
+wait "${pids[@]}"`
+
+// notificationEvent is an S3 event record sent by backbeat.
+type notificationEvent struct {
+	EventTime string `json:"eventTime"`
+	EventName string `json:"eventName"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key       string `json:"key"`
+			Size      int64  `json:"size"`
+			VersionID string `json:"versionId"`
+		} `json:"object"`
+	} `json:"s3"`
+
+	raw json.RawMessage
+}
+
+// notificationFilter selects and prints the notification events.
+type notificationFilter struct {
+	buckets []string
+	events  []string
+	json    bool
+}
+
+func (c *NotificationsTailCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+	if !cfg.Features.BucketNotifications.Enabled {
+		return errors.New("bucket notifications are disabled, enable features.bucket_notifications in values.yaml")
+	}
+
+	destination, err := cfg.Features.BucketNotifications.topicDestination(c.Topic)
+	if err != nil {
+		return err
+	}
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	if c.Expect {
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	fromBeginning := ""
+	if c.FromBeginning {
+		fromBeginning = "true"
+	}
+	command := []string{"bash", "-c", notificationConsumer, "bash",
+		destination.Address(), destination.clientProperties(), c.Topic, fromBeginning}
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+
+	var stopOnce sync.Once
+	stopped := false
+	stop := func() {
+		stopOnce.Do(func() {
+			stopped = true
+			stdinW.Close()
+			stdoutR.Close()
+		})
+	}
+
+	type execResult struct {
+		code int
+		err  error
+	}
+	done := make(chan execResult, 1)
+	go func() {
+		code, err := orch.Exec(context.Background(), ExecOptions{
+			Service: notificationsService,
+			Command: command,
+			Stdin:   stdinR,
+			Stdout:  stdoutW,
+			Stderr:  os.Stderr,
+		})
+		stdoutW.Close()
+		done <- execResult{code, err}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	filter := notificationFilter{buckets: c.Bucket, events: c.Event, json: c.JSON}
+	matched := false
+	scanner := bufio.NewScanner(stdoutR)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for !matched && scanner.Scan() {
+		if scanner.Text() == notificationsReady {
+			log.Info().Str("topic", c.Topic).Msg("Waiting for notifications")
+			continue
+		}
+		events, err := parseNotification(scanner.Bytes())
+		if err != nil {
+			log.Warn().Err(err).Str("message", scanner.Text()).Msg("Ignoring message that is not an S3 event")
+			continue
+		}
+		for _, event := range events {
+			if !filter.matches(event) {
+				continue
+			}
+			filter.print(event, "")
+			if c.Expect {
+				matched = true
+				break
+			}
+		}
+	}
+	stop()
+
+	select {
+	case result := <-done:
+		if !stopped && result.err != nil {
+			return fmt.Errorf("failed to consume notifications: %w", result.err)
+		}
+		if !stopped && result.code != 0 {
+			return fmt.Errorf("notifications consumer exited with code %d, is the environment running?", result.code)
+		}
+	case <-time.After(10 * time.Second):
+		log.Warn().Msg("Notifications consumer did not stop")
+	}
+
+	if c.Expect && !matched {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("no matching notification within %s", c.Timeout)
+		}
+		return errors.New("no matching notification")
+	}
+	return nil
+}
+
+// topicDestination returns the kafka destination receiving the events of a
+// topic.
+func (c BucketNotificationsFeatureConfig) topicDestination(topic string) (NotificationDestination, error) {
+	var topics []string
+	for _, d := range c.Destinations {
+		if d.Type != notificationDestinationKafka {
+			continue
+		}
+		if d.Topic == topic {
+			return d, nil
+		}
+		topics = append(topics, d.Topic)
+	}
+	return NotificationDestination{}, fmt.Errorf("no kafka destination uses topic %q (topics: %s)", topic, strings.Join(topics, ", "))
+}
+
+// clientProperties returns the settings of kafka clients connecting to a
+// destination, like config/kafka/config.properties for destinationAuth.
+func (d NotificationDestination) clientProperties() string {
+	auth := d.Auth
+	switch {
+	case auth.Type == notificationAuthBasic:
+		return fmt.Sprintf(`sasl.jaas.config=org.apache.kafka.common.security.plain.PlainLoginModule required username="%s" password="%s";
+security.protocol=SASL_PLAINTEXT
+sasl.mechanism=PLAIN`, auth.Username, auth.Password)
+	case auth.Mechanism() != "":
+		return fmt.Sprintf(`sasl.jaas.config=org.apache.kafka.common.security.scram.ScramLoginModule required username="%s" password="%s";
+security.protocol=SASL_PLAINTEXT
+sasl.mechanism=%s`, auth.Username, auth.Password, auth.Mechanism())
+	case auth.Type == notificationAuthSSL:
+		return fmt.Sprintf(`security.protocol=SSL
+ssl.keystore.type=PEM
+ssl.keystore.location=/opt/kafka/config/tls/destination-%s.pem
+ssl.truststore.type=PEM
+ssl.truststore.location=/opt/kafka/config/tls/ca.crt`, d.Resource)
+	}
+	return ""
+}
+
+// parseNotification returns the event records of a notification message.
+func parseNotification(message []byte) ([]notificationEvent, error) {
+	var msg struct {
+		Records []json.RawMessage `json:"Records"`
+	}
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return nil, err
+	}
+	if msg.Records == nil {
+		return nil, errors.New("no Records")
+	}
+
+	events := make([]notificationEvent, 0, len(msg.Records))
+	for _, record := range msg.Records {
+		var event notificationEvent
+		if err := json.Unmarshal(record, &event); err != nil {
+			return nil, err
+		}
+		event.raw = record
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (f notificationFilter) matches(event notificationEvent) bool {
+	if len(f.buckets) > 0 && !slices.Contains(f.buckets, event.S3.Bucket.Name) {
+		return false
+	}
+	if len(f.events) == 0 {
+		return true
+	}
+	for _, pattern := range f.events {
+		// The s3: prefix of event names is optional in patterns
+		if ok, _ := path.Match(pattern, event.EventName); ok {
+			return true
+		}
+		if ok, _ := path.Match("s3:"+pattern, event.EventName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// print prints an event, followed by suffix in the pretty format.
+func (f notificationFilter) print(event notificationEvent, suffix string) {
+	if f.json {
+		fmt.Println(string(event.raw))
+		return
+	}
+
+	line := fmt.Sprintf("%s %s %s/%s", event.EventTime, event.EventName, event.S3.Bucket.Name, event.S3.Object.Key)
+	if strings.HasPrefix(event.EventName, "s3:ObjectCreated:") {
+		line += fmt.Sprintf(" size=%d", event.S3.Object.Size)
+	}
+	if event.S3.Object.VersionID != "" {
+		line += " version=" + event.S3.Object.VersionID
+	}
+	fmt.Println(line + suffix)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testNotificationDestinations = `features:
+  bucket_notifications:
+    enabled: true
+    destinations:
+      - resource: destination1
+      - resource: secured
+        topic: secured-notifications
+        auth:
+          type: scram-sha-512
+          username: client
+          password: client123
+      - resource: mtls
+        topic: mtls-notifications
+        auth:
+          type: ssl
+      - resource: external
+        host: kafka.example.com:9092
+        topic: s3-events
+      - resource: webhook1
+        type: webhook
+`
+
+func TestTopicDestination(t *testing.T) {
+	notifications := BucketNotificationsFeatureConfig{
+		Enabled:         true,
+		DestinationAuth: NotificationAuthConfig{Type: notificationAuthNone},
+		Destinations: []NotificationDestination{
+			{Resource: "destination1"},
+			{Resource: "secured", Topic: "secured-notifications", Auth: NotificationAuthConfig{Type: notificationAuthBasic, Username: "client", Password: "client123"}},
+			{Resource: "mtls", Topic: "mtls-notifications", Auth: NotificationAuthConfig{Type: notificationAuthSSL}},
+			{Resource: "external", Host: "kafka.example.com:9092", Topic: "s3-events"},
+			{Resource: "webhook1", Type: notificationDestinationWebhook},
+		},
+	}
+	if err := notifications.resolve(9300); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		topic      string
+		resource   string
+		address    string
+		properties []string
+	}{
+		{topic: "notifications", resource: "destination1", address: "127.0.0.1:9094"},
+		{
+			topic:      "secured-notifications",
+			resource:   "secured",
+			address:    "127.0.0.1:9096",
+			properties: []string{`PlainLoginModule required username="client" password="client123";`, "sasl.mechanism=PLAIN"},
+		},
+		{
+			topic:      "mtls-notifications",
+			resource:   "mtls",
+			address:    "127.0.0.1:9097",
+			properties: []string{"security.protocol=SSL", "ssl.keystore.location=/opt/kafka/config/tls/destination-mtls.pem"},
+		},
+		{topic: "s3-events", resource: "external", address: "kafka.example.com:9092"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.topic, func(t *testing.T) {
+			d, err := notifications.topicDestination(tt.topic)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Resource != tt.resource || d.Address() != tt.address {
+				t.Errorf("got destination %s at %s, want %s at %s", d.Resource, d.Address(), tt.resource, tt.address)
+			}
+			properties := d.clientProperties()
+			if len(tt.properties) == 0 && properties != "" {
+				t.Errorf("expected no client properties, got %q", properties)
+			}
+			for _, p := range tt.properties {
+				if !strings.Contains(properties, p) {
+					t.Errorf("expected %q in the client properties, got:\n%s", p, properties)
+				}
+			}
+		})
+	}
+
+	if _, err := notifications.topicDestination("unknown"); err == nil {
+		t.Error("expected an error for a topic without destination")
+	}
+}
+
+func TestNotificationsTailCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, envPath := newTestEnv(t)
+
+	values := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(values, []byte(testNotificationDestinations), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeValuesOverrides(envPath, []string{values}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	orch.ExecOutput = notificationsReady + "\n" +
+		`{"Records":[{"eventName":"s3:ObjectCreated:Put","s3":{"bucket":{"name":"photos"},"object":{"key":"cat.jpg","size":4}}}]}` + "\n"
+	cmd := NotificationsTailCmd{EnvDir: envDir, Name: DefaultEnvName, Topic: "secured-notifications", Bucket: []string{"photos"}, Expect: true, Timeout: 10 * time.Second}
+	out, err := captureStdout(t, cmd.Run)
+	if err != nil {
+		t.Fatalf("tail failed: %v", err)
+	}
+	if !strings.Contains(out, "s3:ObjectCreated:Put photos/cat.jpg size=4") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	if len(orch.Calls) != 1 {
+		t.Fatalf("expected a single exec, got %q", orch.Calls)
+	}
+	for _, arg := range []string{"127.0.0.1:9096", "sasl.mechanism=SCRAM-SHA-512", "secured-notifications"} {
+		if !strings.Contains(orch.Calls[0], arg) {
+			t.Errorf("expected %q in the consumer command, got %q", arg, orch.Calls[0])
+		}
+	}
+}
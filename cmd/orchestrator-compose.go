@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// composeOrchestrator shells out to the docker compose CLI.
+type composeOrchestrator struct {
+	cfg     EnvironmentConfig
+	envPath string
+}
+
+func (o *composeOrchestrator) Up(ctx context.Context, opts UpOptions) error {
+	args := []string{"up"}
+	if opts.Detach {
+		args = append(args, "--detach")
+	}
+	if opts.Build {
+		args = append(args, "--build")
+	}
+	if opts.NoCache {
+		args = append(args, "--no-cache")
+	}
+	if opts.Pull != "" {
+		args = append(args, "--pull", opts.Pull)
+	}
+	if opts.NoDeps {
+		args = append(args, "--no-deps")
+	}
+	if opts.ForceRecreate {
+		args = append(args, "--force-recreate")
+	}
+	args = append(args, opts.Services...)
+	return runDockerCompose(ctx, o.cfg, o.envPath, args...)
+}
+
+func (o *composeOrchestrator) Down(ctx context.Context, opts DownOptions) error {
+	args := []string{"down", "--timeout", fmt.Sprintf("%d", opts.Timeout)}
+	if opts.Volumes {
+		args = append(args, "--volumes")
+	}
+	return runDockerCompose(ctx, o.cfg, o.envPath, args...)
+}
+
+func (o *composeOrchestrator) Stop(ctx context.Context) error {
+	return runDockerCompose(ctx, o.cfg, o.envPath, "stop")
+}
+
+func (o *composeOrchestrator) Start(ctx context.Context) error {
+	return runDockerCompose(ctx, o.cfg, o.envPath, "start")
+}
+
+func (o *composeOrchestrator) Restart(ctx context.Context, services []string) error {
+	return runDockerCompose(ctx, o.cfg, o.envPath, append([]string{"restart"}, services...)...)
+}
+
+// composePsEntry is an entry of docker compose ps --format json.
+type composePsEntry struct {
+	Service  string `json:"Service"`
+	Name     string `json:"Name"`
+	State    string `json:"State"`
+	Health   string `json:"Health"`
+	ExitCode int    `json:"ExitCode"`
+}
+
+func (o *composeOrchestrator) Ps(ctx context.Context) ([]ContainerState, error) {
+	out, err := commandOutput(ctx, o.envPath, buildDockerComposeCommand(o.cfg, "ps", "--all", "--format", "json")...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compose before 2.21 prints an array, later versions a line per container
+	var entries []composePsEntry
+	out = bytes.TrimSpace(out)
+	if bytes.HasPrefix(out, []byte("[")) {
+		if err := json.Unmarshal(out, &entries); err != nil {
+			return nil, fmt.Errorf("failed to parse containers: %w", err)
+		}
+	} else {
+		for _, line := range bytes.Split(out, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			var entry composePsEntry
+			if err := json.Unmarshal(line, &entry); err != nil {
+				return nil, fmt.Errorf("failed to parse containers: %w", err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	containers := make([]ContainerState, 0, len(entries))
+	for _, e := range entries {
+		containers = append(containers, ContainerState(e))
+	}
+	return containers, nil
+}
+
+func (o *composeOrchestrator) Logs(ctx context.Context, opts LogsOptions, handle func(service, line string)) error {
+	args := []string{"logs"}
+	if opts.Follow {
+		args = append(args, "--follow")
+	}
+	if opts.Since != "" {
+		args = append(args, "--since", opts.Since)
+	}
+	if opts.Until != "" {
+		args = append(args, "--until", opts.Until)
+	}
+	if opts.Timestamps {
+		args = append(args, "--timestamps")
+	}
+	if opts.Tail != "" {
+		args = append(args, "--tail", opts.Tail)
+	}
+	args = append(args, opts.Services...)
+
+	compose, err := loadComposeFile(o.envPath)
+	if err != nil {
+		return err
+	}
+
+	dockerComposeCmd := buildDockerComposeCommand(o.cfg, args...)
+	log.Debug().Str("command", strings.Join(dockerComposeCmd, " ")).Msg("Retrieving logs")
+
+	cmd := exec.CommandContext(ctx, dockerComposeCmd[0], dockerComposeCmd[1:]...)
+	cmd.Stderr = os.Stderr
+	cmd.Dir = o.envPath
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		handle(splitComposeLine(compose, scanner.Text()))
+	}
+
+	return cmd.Wait()
+}
+
+func (o *composeOrchestrator) Exec(ctx context.Context, opts ExecOptions) (int, error) {
+	args := []string{"exec"}
+	if !opts.TTY {
+		args = append(args, "--no-TTY")
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	for _, e := range opts.Env {
+		args = append(args, "--env", e)
+	}
+	args = append(args, opts.Service)
+	args = append(args, opts.Command...)
+
+	dockerComposeCmd := buildDockerComposeCommand(o.cfg, args...)
+	log.Debug().Str("command", strings.Join(dockerComposeCmd, " ")).Msg("Running command in container")
+
+	// Not bound to ctx, signals are forwarded to the container by docker
+	cmd := exec.Command(dockerComposeCmd[0], dockerComposeCmd[1:]...)
+	cmd.Stdin = opts.Stdin
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	cmd.Dir = o.envPath
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+func (o *composeOrchestrator) Inspect(ctx context.Context, names []string) ([]byte, error) {
+	return commandOutput(ctx, o.envPath, append(currentContainerEngine().command("inspect"), names...)...)
+}
+
+func (o *composeOrchestrator) Run(ctx context.Context, opts RunOptions) (int, error) {
+	args := currentContainerEngine().command("run", "--rm")
+	for _, v := range opts.Volumes {
+		args = append(args, "--volume", v)
+	}
+	args = append(args, opts.Image)
+	args = append(args, opts.Command...)
+	log.Debug().Str("command", strings.Join(args, " ")).Msg("Running container")
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
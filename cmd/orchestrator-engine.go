@@ -0,0 +1,743 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// engineOrchestrator talks to the Docker Engine API, finding the containers
+// of the environment by their compose labels. Creating the containers of a
+// compose file (builds, networks, volumes and container definitions) is left
+// to compose, hence the compose-engine name: Up and interactive Exec go
+// through compose, the other operations through the API.
+type engineOrchestrator struct {
+	compose *composeOrchestrator
+	client  *engineClient
+	project string
+}
+
+func newEngineOrchestrator(cfg EnvironmentConfig, envPath string) (*engineOrchestrator, error) {
+	client, err := newEngineClient(os.Getenv("DOCKER_HOST"))
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := composeProject(envPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &engineOrchestrator{
+		compose: &composeOrchestrator{cfg: cfg, envPath: envPath},
+		client:  client,
+		project: project,
+	}, nil
+}
+
+// composeProject returns the project name compose uses for an environment:
+// COMPOSE_PROJECT_NAME, the name of the compose file or the directory name.
+func composeProject(envPath string) (string, error) {
+	if name := os.Getenv("COMPOSE_PROJECT_NAME"); name != "" {
+		return name, nil
+	}
+
+	compose, err := loadComposeFile(envPath)
+	if err != nil {
+		return "", err
+	}
+	if compose.Name != "" {
+		return compose.Name, nil
+	}
+
+	abs, err := filepath.Abs(envPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", envPath, err)
+	}
+	name := invalidProjectChars.ReplaceAllString(strings.ToLower(filepath.Base(abs)), "")
+	return strings.TrimLeft(name, "_-"), nil
+}
+
+var invalidProjectChars = regexp.MustCompile(`[^a-z0-9_-]`)
+
+// Up creates the containers with compose, then reports their progress.
+func (o *engineOrchestrator) Up(ctx context.Context, opts UpOptions) error {
+	if !opts.Detach {
+		// compose attaches to the containers and stops them on interrupt
+		return o.compose.Up(ctx, opts)
+	}
+
+	if err := o.compose.Up(ctx, opts); err != nil {
+		return err
+	}
+	return o.waitReady(ctx, opts.Services)
+}
+
+// waitReady reports the state changes of the containers of services, all of
+// them when empty, until they are running and healthy, and setup containers
+// have completed.
+func (o *engineOrchestrator) waitReady(ctx context.Context, services []string) error {
+	seen := map[string]ContainerState{}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		containers, err := o.Ps(ctx)
+		if err != nil {
+			return err
+		}
+
+		ready := true
+		for _, c := range containers {
+			if len(services) > 0 && !slices.Contains(services, c.Service) {
+				continue
+			}
+			if prev, ok := seen[c.Name]; !ok || prev.State != c.State || prev.Health != c.Health {
+				log.Info().Str("service", c.Service).Str("state", c.State).Str("health", c.Health).Msg("Container changed")
+				seen[c.Name] = c
+			}
+
+			switch {
+			case c.Health == "unhealthy":
+				return fmt.Errorf("container %s is unhealthy", c.Name)
+			case isOneShotService(c.Service) && c.State == "exited" && c.ExitCode != 0:
+				return fmt.Errorf("container %s exited with code %d", c.Name, c.ExitCode)
+			case isOneShotService(c.Service) && c.State == "exited":
+			case c.State == "exited" || c.State == "dead":
+				return fmt.Errorf("container %s exited with code %d", c.Name, c.ExitCode)
+			case c.State != "running" || c.Health == "starting":
+				ready = false
+			}
+		}
+
+		if ready {
+			if len(services) == 0 {
+				log.Info().Int("containers", len(containers)).Msg("Environment is ready")
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *engineOrchestrator) Down(ctx context.Context, opts DownOptions) error {
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		name := c.name()
+		log.Info().Str("container", name).Msg("Removing container")
+		stop := url.Values{"t": {strconv.Itoa(opts.Timeout)}}
+		if err := o.client.do(ctx, http.MethodPost, "/containers/"+c.ID+"/stop", stop, nil, nil); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", name, err)
+		}
+		// Anonymous volumes go with their container, like with compose down
+		remove := url.Values{"v": {"true"}, "force": {"true"}}
+		if err := o.client.do(ctx, http.MethodDelete, "/containers/"+c.ID, remove, nil, nil); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+
+	var networks []struct {
+		ID   string `json:"Id"`
+		Name string `json:"Name"`
+	}
+	filter := url.Values{"filters": {o.projectFilter()}}
+	if err := o.client.get(ctx, "/networks", filter, &networks); err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		if err := o.client.do(ctx, http.MethodDelete, "/networks/"+n.ID, nil, nil, nil); err != nil {
+			return fmt.Errorf("failed to remove network %s: %w", n.Name, err)
+		}
+	}
+
+	if !opts.Volumes {
+		return nil
+	}
+
+	var volumes struct {
+		Volumes []struct {
+			Name string `json:"Name"`
+		} `json:"Volumes"`
+	}
+	if err := o.client.get(ctx, "/volumes", filter, &volumes); err != nil {
+		return fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, v := range volumes.Volumes {
+		log.Info().Str("volume", v.Name).Msg("Removing volume")
+		if err := o.client.do(ctx, http.MethodDelete, "/volumes/"+v.Name, nil, nil, nil); err != nil {
+			return fmt.Errorf("failed to remove volume %s: %w", v.Name, err)
+		}
+	}
+	return nil
+}
+
+func (o *engineOrchestrator) Stop(ctx context.Context) error {
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		if c.State != "running" {
+			continue
+		}
+		name := c.name()
+		log.Info().Str("container", name).Msg("Stopping container")
+		if err := o.client.do(ctx, http.MethodPost, "/containers/"+c.ID+"/stop", nil, nil, nil); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Start starts the stopped containers in the order of their dependencies,
+// waiting for each group of services to be ready before starting the next
+// one, like compose does with the conditions of depends_on.
+func (o *engineOrchestrator) Start(ctx context.Context) error {
+	compose, err := loadComposeFile(o.compose.envPath)
+	if err != nil {
+		return err
+	}
+
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return err
+	}
+	byService := map[string][]engineContainer{}
+	for _, c := range containers {
+		service := c.Labels["com.docker.compose.service"]
+		byService[service] = append(byService[service], c)
+	}
+
+	groups, err := compose.startOrder(slices.Collect(maps.Keys(byService)))
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		for _, service := range group {
+			for _, c := range byService[service] {
+				if c.State == "running" {
+					continue
+				}
+				name := c.name()
+				log.Info().Str("container", name).Msg("Starting container")
+				if err := o.client.do(ctx, http.MethodPost, "/containers/"+c.ID+"/start", nil, nil, nil); err != nil {
+					return fmt.Errorf("failed to start %s: %w", name, err)
+				}
+			}
+		}
+		if err := o.waitReady(ctx, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *engineOrchestrator) Restart(ctx context.Context, services []string) error {
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range containers {
+		if !slices.Contains(services, c.Labels["com.docker.compose.service"]) {
+			continue
+		}
+		name := c.name()
+		log.Info().Str("container", name).Msg("Restarting container")
+		if err := o.client.do(ctx, http.MethodPost, "/containers/"+c.ID+"/restart", nil, nil, nil); err != nil {
+			return fmt.Errorf("failed to restart %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (o *engineOrchestrator) projectFilter() string {
+	filter, _ := json.Marshal(map[string][]string{"label": {"com.docker.compose.project=" + o.project}})
+	return string(filter)
+}
+
+// engineContainer is an entry of GET /containers/json.
+type engineContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+func (c engineContainer) name() string {
+	if len(c.Names) == 0 {
+		return c.ID
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+var exitCodeStatus = regexp.MustCompile(`^Exited \((\d+)\)`)
+
+// state decodes the human readable status, e.g. "Up 2 minutes (healthy)",
+// which spares an inspect per container.
+func (c engineContainer) state() ContainerState {
+	state := ContainerState{
+		Service: c.Labels["com.docker.compose.service"],
+		Name:    c.name(),
+		State:   c.State,
+	}
+
+	switch {
+	case strings.Contains(c.Status, "(health: starting)"):
+		state.Health = "starting"
+	case strings.Contains(c.Status, "(unhealthy)"):
+		state.Health = "unhealthy"
+	case strings.Contains(c.Status, "(healthy)"):
+		state.Health = "healthy"
+	}
+
+	if m := exitCodeStatus.FindStringSubmatch(c.Status); m != nil {
+		state.ExitCode, _ = strconv.Atoi(m[1])
+	}
+	return state
+}
+
+func (o *engineOrchestrator) containers(ctx context.Context) ([]engineContainer, error) {
+	var containers []engineContainer
+	query := url.Values{"all": {"true"}, "filters": {o.projectFilter()}}
+	if err := o.client.get(ctx, "/containers/json", query, &containers); err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	slices.SortFunc(containers, func(a, b engineContainer) int { return strings.Compare(a.name(), b.name()) })
+	return containers, nil
+}
+
+func (o *engineOrchestrator) Ps(ctx context.Context) ([]ContainerState, error) {
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]ContainerState, 0, len(containers))
+	for _, c := range containers {
+		states = append(states, c.state())
+	}
+	return states, nil
+}
+
+func (o *engineOrchestrator) Logs(ctx context.Context, opts LogsOptions, handle func(service, line string)) error {
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{
+		"stdout":     {"true"},
+		"stderr":     {"true"},
+		"follow":     {strconv.FormatBool(opts.Follow)},
+		"timestamps": {strconv.FormatBool(opts.Timestamps)},
+	}
+	if opts.Tail != "" {
+		query.Set("tail", opts.Tail)
+	}
+	for key, value := range map[string]string{"since": opts.Since, "until": opts.Until} {
+		if value == "" {
+			continue
+		}
+		t, err := parseLogTime(value, time.Now())
+		if err != nil {
+			return err
+		}
+		query.Set(key, strconv.FormatInt(t.Unix(), 10))
+	}
+
+	// Streams of all containers are read concurrently, lines are handled
+	// one at a time. Streams left behind by an early return are stopped by
+	// the cancellation.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	lines := make(chan [2]string)
+	errs := make(chan error, len(containers))
+	streams := 0
+	for _, c := range containers {
+		service := c.Labels["com.docker.compose.service"]
+		if len(opts.Services) > 0 && !slices.Contains(opts.Services, service) {
+			continue
+		}
+		var inspect struct {
+			Config struct {
+				Tty bool `json:"Tty"`
+			} `json:"Config"`
+		}
+		if err := o.client.get(ctx, "/containers/"+c.ID+"/json", nil, &inspect); err != nil {
+			return fmt.Errorf("failed to inspect %s: %w", c.name(), err)
+		}
+
+		streams++
+		go func(c engineContainer, service string, tty bool) {
+			errs <- o.client.stream(ctx, "/containers/"+c.ID+"/logs", query, tty, func(line string) {
+				select {
+				case lines <- [2]string{service, line}:
+				case <-ctx.Done():
+				}
+			})
+		}(c, service, inspect.Config.Tty)
+	}
+
+	var errList []error
+	for streams > 0 {
+		select {
+		case l := <-lines:
+			handle(l[0], l[1])
+		case err := <-errs:
+			streams--
+			if err != nil && !errors.Is(err, context.Canceled) {
+				errList = append(errList, err)
+			}
+		}
+	}
+	return errors.Join(errList...)
+}
+
+func (o *engineOrchestrator) Exec(ctx context.Context, opts ExecOptions) (int, error) {
+	if opts.TTY || opts.Stdin != nil {
+		// Attaching stdin needs a hijacked connection and a raw terminal
+		return o.compose.Exec(ctx, opts)
+	}
+
+	containers, err := o.containers(ctx)
+	if err != nil {
+		return 0, err
+	}
+	idx := slices.IndexFunc(containers, func(c engineContainer) bool {
+		return c.Labels["com.docker.compose.service"] == opts.Service && c.State == "running"
+	})
+	if idx < 0 {
+		return 0, fmt.Errorf("service %s is not running", opts.Service)
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	body := map[string]any{
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          opts.Command,
+		"Env":          opts.Env,
+		"User":         opts.User,
+	}
+	if err := o.client.do(ctx, http.MethodPost, "/containers/"+containers[idx].ID+"/exec", nil, body, &created); err != nil {
+		return 0, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	err = o.client.streamFrames(ctx, http.MethodPost, "/exec/"+created.ID+"/start", map[string]any{"Detach": false, "Tty": false},
+		func(stream byte, data []byte) {
+			if stream == 2 {
+				_, _ = opts.Stderr.Write(data)
+			} else {
+				_, _ = opts.Stdout.Write(data)
+			}
+		})
+	if err != nil {
+		return 0, fmt.Errorf("failed to run exec: %w", err)
+	}
+
+	var inspect struct {
+		ExitCode int `json:"ExitCode"`
+	}
+	if err := o.client.get(ctx, "/exec/"+created.ID+"/json", nil, &inspect); err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return inspect.ExitCode, nil
+}
+
+func (o *engineOrchestrator) Inspect(ctx context.Context, names []string) ([]byte, error) {
+	inspects := make([]json.RawMessage, 0, len(names))
+	for _, name := range names {
+		var inspect json.RawMessage
+		if err := o.client.get(ctx, "/containers/"+name+"/json", nil, &inspect); err != nil {
+			return nil, fmt.Errorf("failed to inspect %s: %w", name, err)
+		}
+		inspects = append(inspects, inspect)
+	}
+	return json.MarshalIndent(inspects, "", "    ")
+}
+
+// Run pulls the image if missing, then runs the container to completion and
+// removes it.
+func (o *engineOrchestrator) Run(ctx context.Context, opts RunOptions) (int, error) {
+	if err := o.client.get(ctx, "/images/"+opts.Image+"/json", nil, nil); err != nil {
+		if err := o.client.pull(ctx, opts.Image); err != nil {
+			return 0, err
+		}
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	body := map[string]any{
+		"Image":      opts.Image,
+		"Cmd":        opts.Command,
+		"HostConfig": map[string]any{"Binds": opts.Volumes},
+	}
+	if err := o.client.do(ctx, http.MethodPost, "/containers/create", nil, body, &created); err != nil {
+		return 0, fmt.Errorf("failed to create container: %w", err)
+	}
+	defer func() {
+		// Removed even when interrupted
+		remove := url.Values{"v": {"true"}, "force": {"true"}}
+		if err := o.client.do(context.WithoutCancel(ctx), http.MethodDelete, "/containers/"+created.ID, remove, nil, nil); err != nil {
+			log.Warn().Err(err).Str("container", created.ID).Msg("Failed to remove container")
+		}
+	}()
+
+	if err := o.client.do(ctx, http.MethodPost, "/containers/"+created.ID+"/start", nil, nil, nil); err != nil {
+		return 0, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	var wait struct {
+		StatusCode int `json:"StatusCode"`
+	}
+	if err := o.client.do(ctx, http.MethodPost, "/containers/"+created.ID+"/wait", nil, nil, &wait); err != nil {
+		return 0, fmt.Errorf("failed to wait for container: %w", err)
+	}
+
+	resp, err := o.client.request(ctx, http.MethodGet, "/containers/"+created.ID+"/logs", url.Values{"stdout": {"true"}, "stderr": {"true"}}, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read container output: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	err = demuxStream(resp.Body, false, func(stream byte, data []byte) {
+		w := opts.Stdout
+		if stream == 2 {
+			w = opts.Stderr
+		}
+		if w != nil {
+			_, _ = w.Write(data)
+		}
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read container output: %w", err)
+	}
+	return wait.StatusCode, nil
+}
+
+// engineClient is a minimal Docker Engine API client.
+type engineClient struct {
+	http    *http.Client
+	baseURL string
+}
+
+// newEngineClient connects to host, a DOCKER_HOST value, or to the default
+// unix socket.
+func newEngineClient(host string) (*engineClient, error) {
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &engineClient{http: &http.Client{Transport: transport}, baseURL: "http://docker"}, nil
+	case "tcp":
+		return &engineClient{http: &http.Client{}, baseURL: "http://" + u.Host}, nil
+	}
+	return nil, fmt.Errorf("DOCKER_HOST %q is not supported by the engine orchestrator, use --orchestrator=compose", host)
+}
+
+func (c *engineClient) request(ctx context.Context, method, path string, query url.Values, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// 304 is returned when stopping a stopped container
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotModified {
+		defer func() { _ = resp.Body.Close() }()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return nil, fmt.Errorf("%s %s: %s", method, path, apiErr.Message)
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (c *engineClient) do(ctx context.Context, method, path string, query url.Values, body, out any) error {
+	resp, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if out == nil || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *engineClient) get(ctx context.Context, path string, query url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, query, nil, out)
+}
+
+// pull pulls an image, reading the progress messages until it completes.
+func (c *engineClient) pull(ctx context.Context, image string) error {
+	log.Info().Str("image", image).Msg("Pulling image")
+	resp, err := c.request(ctx, http.MethodPost, "/images/create", url.Values{"fromImage": {image}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", image, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if err := decoder.Decode(&progress); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to pull %s: %w", image, err)
+		}
+		if progress.Error != "" {
+			return fmt.Errorf("failed to pull %s: %s", image, progress.Error)
+		}
+	}
+}
+
+// stream reads the lines of the output of a container, e.g. its logs.
+func (c *engineClient) stream(ctx context.Context, path string, query url.Values, tty bool, handle func(line string)) error {
+	resp, err := c.request(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Lines may be split across frames, stdout and stderr are split separately
+	partial := map[byte]string{}
+	err = demuxStream(resp.Body, tty, func(stream byte, data []byte) {
+		text := partial[stream] + string(data)
+		for {
+			line, rest, found := strings.Cut(text, "\n")
+			if !found {
+				break
+			}
+			handle(line)
+			text = rest
+		}
+		partial[stream] = text
+	})
+	for _, text := range partial {
+		if text != "" {
+			handle(text)
+		}
+	}
+	return err
+}
+
+// streamFrames posts a request and calls handle with the frames of the
+// multiplexed stream of the response.
+func (c *engineClient) streamFrames(ctx context.Context, method, path string, body any, handle func(stream byte, data []byte)) error {
+	resp, err := c.request(ctx, method, path, nil, body)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	return demuxStream(resp.Body, false, handle)
+}
+
+// demuxStream splits the stdout (1) and stderr (2) frames of a stream. Each
+// frame has an 8 bytes header holding the stream and the size of the frame.
+// The output of TTY containers is not multiplexed.
+func demuxStream(r io.Reader, tty bool, handle func(stream byte, data []byte)) error {
+	if tty {
+		reader := bufio.NewReader(r)
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := reader.Read(buf)
+			if n > 0 {
+				handle(1, buf[:n])
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		handle(header[0], data)
+	}
+}
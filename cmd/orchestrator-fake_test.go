@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeOrchestrator records the operations of a command instead of running
+// containers, so command logic can be exercised without Docker.
+type fakeOrchestrator struct {
+	mu sync.Mutex
+	// services are started by Up
+	services   []string
+	containers map[string]ContainerState
+	// Calls lists the operations in order, e.g. "up detach=true"
+	Calls []string
+	// LogLines are passed to the handler of Logs, by service
+	LogLines map[string][]string
+	// ExecOutput is written to the stdout of Exec
+	ExecOutput string
+	// ExitCode is returned by Exec and Run
+	ExitCode int
+}
+
+// useFakeOrchestrator makes the commands of the test use a fake orchestrator,
+// loading the services of the environment they run in.
+func useFakeOrchestrator(t *testing.T) *fakeOrchestrator {
+	t.Helper()
+	o := &fakeOrchestrator{containers: map[string]ContainerState{}}
+	testOrchestrator = func(cfg EnvironmentConfig, envPath string) Orchestrator {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if compose, err := loadComposeFile(envPath); err == nil {
+			o.services = compose.activeServices(getComposeProfiles(cfg))
+		}
+		return o
+	}
+	t.Cleanup(func() { testOrchestrator = nil })
+	return o
+}
+
+// newTestEnv creates an environment with the default values in a temporary
+// env dir, enabling the given features.
+func newTestEnv(t *testing.T, features ...string) (envDir, envPath string) {
+	t.Helper()
+	engine := CLI.ContainerEngine
+	CLI.ContainerEngine = containerEngineDocker
+	t.Cleanup(func() { CLI.ContainerEngine = engine })
+	envDir = t.TempDir()
+	envPath, err := createEnv(envDir, DefaultEnvName, false, "", "")
+	if err != nil {
+		t.Fatalf("failed to create environment: %v", err)
+	}
+	if err := writeValuesOverrides(envPath, nil, features, false); err != nil {
+		t.Fatalf("failed to enable features: %v", err)
+	}
+	return envDir, envPath
+}
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan []byte)
+	go func() {
+		var buf bytes.Buffer
+		_, _ = io.Copy(&buf, r)
+		done <- buf.Bytes()
+	}()
+
+	runErr := fn()
+	_ = w.Close()
+	return string(<-done), runErr
+}
+
+func assertCalls(t *testing.T, orch *fakeOrchestrator, expected ...string) {
+	t.Helper()
+	if !slices.Equal(orch.Calls, expected) {
+		t.Fatalf("unexpected orchestrator calls\n got: %q\nwant: %q", orch.Calls, expected)
+	}
+}
+
+func (o *fakeOrchestrator) record(format string, args ...any) {
+	o.Calls = append(o.Calls, strings.TrimSpace(fmt.Sprintf(format, args...)))
+}
+
+func (o *fakeOrchestrator) Up(ctx context.Context, opts UpOptions) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var flags []string
+	if opts.NoDeps {
+		flags = append(flags, "no-deps")
+	}
+	if opts.ForceRecreate {
+		flags = append(flags, "force-recreate")
+	}
+	o.record("up detach=%t build=%t pull=%s %s", opts.Detach, opts.Build, opts.Pull, strings.Join(append(flags, opts.Services...), " "))
+
+	for _, service := range o.services {
+		if len(opts.Services) > 0 && !slices.Contains(opts.Services, service) {
+			continue
+		}
+		state := ContainerState{Service: service, Name: "workbench-" + service, State: "running"}
+		if isOneShotService(service) {
+			state.State = "exited"
+		}
+		o.containers[service] = state
+	}
+	return nil
+}
+
+func (o *fakeOrchestrator) Down(ctx context.Context, opts DownOptions) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("down timeout=%d volumes=%t", opts.Timeout, opts.Volumes)
+
+	o.containers = map[string]ContainerState{}
+	return nil
+}
+
+func (o *fakeOrchestrator) Stop(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("stop")
+
+	for service, c := range o.containers {
+		if c.State == "running" {
+			c.State = "exited"
+			o.containers[service] = c
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrchestrator) Start(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("start")
+
+	for service, c := range o.containers {
+		if !isOneShotService(service) {
+			c.State = "running"
+			o.containers[service] = c
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrchestrator) Restart(ctx context.Context, services []string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("restart %s", strings.Join(services, " "))
+
+	for _, service := range services {
+		if c, ok := o.containers[service]; ok {
+			c.State = "running"
+			o.containers[service] = c
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrchestrator) Ps(ctx context.Context) ([]ContainerState, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	containers := make([]ContainerState, 0, len(o.containers))
+	for _, c := range o.containers {
+		containers = append(containers, c)
+	}
+	slices.SortFunc(containers, func(a, b ContainerState) int { return strings.Compare(a.Name, b.Name) })
+	return containers, nil
+}
+
+func (o *fakeOrchestrator) Logs(ctx context.Context, opts LogsOptions, handle func(service, line string)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("logs %s", strings.Join(opts.Services, " "))
+
+	for _, service := range slices.Sorted(maps.Keys(o.LogLines)) {
+		if len(opts.Services) > 0 && !slices.Contains(opts.Services, service) {
+			continue
+		}
+		for _, line := range o.LogLines[service] {
+			handle(service, line)
+		}
+	}
+	return nil
+}
+
+func (o *fakeOrchestrator) Exec(ctx context.Context, opts ExecOptions) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("exec %s %s", opts.Service, strings.Join(opts.Command, " "))
+
+	if opts.Stdout != nil {
+		if _, err := io.WriteString(opts.Stdout, o.ExecOutput); err != nil {
+			return 0, err
+		}
+	}
+	return o.ExitCode, nil
+}
+
+func (o *fakeOrchestrator) Inspect(ctx context.Context, names []string) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("inspect %s", strings.Join(names, " "))
+
+	var inspects []ContainerState
+	for _, c := range o.containers {
+		if slices.Contains(names, c.Name) {
+			inspects = append(inspects, c)
+		}
+	}
+	return json.Marshal(inspects)
+}
+
+func (o *fakeOrchestrator) Run(ctx context.Context, opts RunOptions) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.record("run %s %s", opts.Image, strings.Join(opts.Command, " "))
+	return o.ExitCode, nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Orchestrator runs the containers of an environment. The compose
+// implementation shells out to docker compose and the compose-engine
+// implementation talks to the Docker Engine API, leaving the creation of the
+// containers to compose. Tests replace them with a fake one through
+// testOrchestrator.
+type Orchestrator interface {
+	Up(ctx context.Context, opts UpOptions) error
+	Down(ctx context.Context, opts DownOptions) error
+	// Stop stops the containers, keeping them for Start
+	Stop(ctx context.Context) error
+	// Start starts the stopped containers again
+	Start(ctx context.Context) error
+	// Restart restarts the containers of services
+	Restart(ctx context.Context, services []string) error
+	Ps(ctx context.Context) ([]ContainerState, error)
+	// Logs calls handle with each line of the container logs
+	Logs(ctx context.Context, opts LogsOptions, handle func(service, line string)) error
+	// Exec runs a command in the container of a service and returns its exit code
+	Exec(ctx context.Context, opts ExecOptions) (int, error)
+	// Inspect returns the low level information of containers as a JSON array
+	Inspect(ctx context.Context, names []string) ([]byte, error)
+	// Run runs a one-off container outside of the services and returns its
+	// exit code, e.g. to remove files owned by root
+	Run(ctx context.Context, opts RunOptions) (int, error)
+}
+
+const (
+	orchestratorCompose = "compose"
+	orchestratorEngine  = "compose-engine"
+)
+
+// testOrchestrator replaces the orchestrator of the commands when set, for
+// tests to run them without containers.
+var testOrchestrator func(cfg EnvironmentConfig, envPath string) Orchestrator
+
+type UpOptions struct {
+	Detach  bool
+	Build   bool
+	NoCache bool
+	// Pull is the compose pull policy, e.g. never
+	Pull string
+	// Services limits up to these services, all of them when empty
+	Services      []string
+	NoDeps        bool
+	ForceRecreate bool
+}
+
+type DownOptions struct {
+	// Timeout in seconds to stop the containers
+	Timeout int
+	Volumes bool
+}
+
+type LogsOptions struct {
+	Services   []string
+	Follow     bool
+	Since      string
+	Until      string
+	Tail       string
+	Timestamps bool
+}
+
+type ExecOptions struct {
+	Service string
+	User    string
+	Env     []string
+	Command []string
+	TTY     bool
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+type RunOptions struct {
+	Image string
+	// Volumes are bind mounts, e.g. /host/path:/data
+	Volumes []string
+	Command []string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// ContainerState is the state of a container of the environment.
+type ContainerState struct {
+	Service string
+	Name    string
+	// State is created, running, exited...
+	State string
+	// Health is empty for containers without a healthcheck
+	Health   string
+	ExitCode int
+}
+
+func newOrchestrator(cfg EnvironmentConfig, envPath string) (Orchestrator, error) {
+	if testOrchestrator != nil {
+		return testOrchestrator(cfg, envPath), nil
+	}
+
+	switch CLI.Orchestrator {
+	case orchestratorCompose, "":
+		return &composeOrchestrator{cfg: cfg, envPath: envPath}, nil
+	case orchestratorEngine:
+		return newEngineOrchestrator(cfg, envPath)
+	}
+	return nil, fmt.Errorf("unknown orchestrator %q", CLI.Orchestrator)
+}
+
+// environmentRunning reports whether containers of the environment are running.
+func environmentRunning(ctx context.Context, orch Orchestrator) (bool, error) {
+	containers, err := orch.Ps(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, c := range containers {
+		if c.State == "running" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+type RestartCmd struct {
+	EnvDir  string   `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name    string   `help:"Name of the environment. default: 'default'" short:"n"`
+	Targets []string `arg:"" help:"Components (e.g. cloudserver, backbeat) or compose services to restart."`
+}
+
+func (c *RestartCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	cfg, engine, err := prepareConfig(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	compose, err := loadComposeFile(envPath)
+	if err != nil {
+		return err
+	}
+	active := compose.activeServices(getComposeProfiles(cfg))
+
+	configDir := filepath.Join(envPath, "config")
+	before, err := hashFiles(configDir)
+	if err != nil {
+		return err
+	}
+
+	var services []string
+	rendered := map[string]bool{}
+	for _, target := range c.Targets {
+		comp, ok := findComponent(target)
+		if !ok {
+			return fmt.Errorf("unknown component or service %q", target)
+		}
+
+		if !rendered[comp.Name] {
+			log.Info().Str("component", comp.Name).Msg("Rendering configuration")
+			if err := comp.Generate(cfg, configDir); err != nil {
+				return fmt.Errorf("failed to generate %s config: %w", comp.Name, err)
+			}
+			rendered[comp.Name] = true
+		}
+
+		targets := comp.Services
+		if target != comp.Name {
+			targets = []string{target}
+		}
+		for _, svc := range targets {
+			if slices.Contains(active, svc) && !slices.Contains(services, svc) {
+				services = append(services, svc)
+			}
+		}
+	}
+
+	if err := shareWritableMounts(envPath, engine); err != nil {
+		return fmt.Errorf("failed to set permissions of writable mounts: %w", err)
+	}
+
+	after, err := hashFiles(configDir)
+	if err != nil {
+		return err
+	}
+
+	changes := affectedServices(compose, envPath, getComposeProfiles(cfg), changedFiles(before, after))
+	for _, svc := range services {
+		if !slices.Contains(changes.Restart, svc) && !slices.Contains(changes.Rebuild, svc) {
+			changes.Restart = append(changes.Restart, svc)
+		}
+	}
+
+	if changes.empty() {
+		log.Warn().Msg("No enabled service to restart")
+		return nil
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	return applyServiceChanges(ctx, orch, changes)
+}
+
+type ReconfigureCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+}
+
+func (c *ReconfigureCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	compose, err := loadComposeFile(envPath)
+	if err != nil {
+		return err
+	}
+
+	configDir := filepath.Join(envPath, "config")
+	defaultsEnv := filepath.Join(envPath, "defaults.env")
+	overrideFile := filepath.Join(envPath, composeOverrideFile)
+
+	before, err := hashFiles(configDir, defaultsEnv, overrideFile)
+	if err != nil {
+		return err
+	}
+
+	if err := configureEnv(cfg, envPath); err != nil {
+		return fmt.Errorf("failed to configure environment: %w", err)
+	}
+
+	after, err := hashFiles(configDir, defaultsEnv, overrideFile)
+	if err != nil {
+		return err
+	}
+
+	changed := changedFiles(before, after)
+	for _, path := range changed {
+		log.Debug().Str("file", path).Msg("Configuration changed")
+	}
+
+	envChanged := slices.Contains(changed, defaultsEnv) || slices.Contains(changed, overrideFile)
+	changes := affectedServices(compose, envPath, getComposeProfiles(cfg), changed)
+
+	if !envChanged && changes.empty() {
+		log.Info().Msg("No configuration changes, nothing to restart")
+		return nil
+	}
+
+	log.Info().
+		Bool("definitionsChanged", envChanged).
+		Strs("restart", changes.Restart).
+		Strs("rebuild", changes.Rebuild).
+		Msg("Services affected by configuration changes")
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	// Images, environment variables and service overrides come from
+	// defaults.env and the override file, compose recreates the containers
+	// whose definition changed.
+	if envChanged {
+		if err := orch.Up(ctx, UpOptions{Detach: true, Build: true}); err != nil {
+			return fmt.Errorf("failed to update environment: %w", err)
+		}
+	}
+
+	return applyServiceChanges(ctx, orch, changes)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestartCmd(t *testing.T) {
+	tests := []struct {
+		name     string
+		targets  []string
+		expected string
+	}{
+		{name: "component", targets: []string{"cloudserver"}, expected: "restart cloudserver s3-data"},
+		{name: "service", targets: []string{"s3-data"}, expected: "restart s3-data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orch := useFakeOrchestrator(t)
+			envDir, _ := newTestEnv(t)
+
+			up := UpCmd{EnvDir: envDir, Name: DefaultEnvName, Detach: true, NoDoctor: true}
+			if err := up.Run(); err != nil {
+				t.Fatalf("up failed: %v", err)
+			}
+			restart := RestartCmd{EnvDir: envDir, Name: DefaultEnvName, Targets: tt.targets}
+			if err := restart.Run(); err != nil {
+				t.Fatalf("restart failed: %v", err)
+			}
+			assertCalls(t, orch, "up detach=true build=false pull=", tt.expected)
+		})
+	}
+}
+
+func TestRestartCmdUnknownTarget(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t)
+
+	restart := RestartCmd{EnvDir: envDir, Name: DefaultEnvName, Targets: []string{"unknown"}}
+	if err := restart.Run(); err == nil {
+		t.Fatal("expected restart to fail on an unknown target")
+	}
+	assertCalls(t, orch)
+}
+
+func TestReconfigureCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, envPath := newTestEnv(t)
+
+	up := UpCmd{EnvDir: envDir, Name: DefaultEnvName, Detach: true, NoDoctor: true}
+	if err := up.Run(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	reconfigure := ReconfigureCmd{EnvDir: envDir, Name: DefaultEnvName}
+	if err := reconfigure.Run(); err != nil {
+		t.Fatalf("reconfigure failed: %v", err)
+	}
+	assertCalls(t, orch, "up detach=true build=false pull=")
+
+	// A new image changes defaults.env, compose recreates the containers
+	values := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(values, []byte("cloudserver:\n  image: ghcr.io/scality/cloudserver:9.2.23\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeValuesOverrides(envPath, []string{values}, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := reconfigure.Run(); err != nil {
+		t.Fatalf("reconfigure failed: %v", err)
+	}
+	assertCalls(t, orch, "up detach=true build=false pull=", "up detach=true build=true pull=")
+}
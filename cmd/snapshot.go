@@ -0,0 +1,603 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+type SnapshotCmd struct {
+	Create  SnapshotCreateCmd  `cmd:"" help:"Snapshot the volumes, persistent data and configuration of an environment."`
+	Restore SnapshotRestoreCmd `cmd:"" help:"Restore a snapshot into the same or a new environment."`
+	List    SnapshotListCmd    `cmd:"" help:"List the snapshots of an environment."`
+	Delete  SnapshotDeleteCmd  `cmd:"" help:"Delete a snapshot."`
+}
+
+type SnapshotCreateCmd struct {
+	EnvDir    string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name      string `help:"Name of the environment to snapshot. default: 'default'" short:"n"`
+	NoRestart bool   `help:"Leave the environment stopped after the snapshot."`
+	Overwrite bool   `help:"Replace an existing snapshot with the same name."`
+	Snapshot  string `arg:"" help:"Name of the snapshot."`
+}
+
+type SnapshotRestoreCmd struct {
+	EnvDir   string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name     string `help:"Name of the environment the snapshot was taken from. default: 'default'" short:"n"`
+	To       string `help:"Name of the environment to restore into. default: the snapshot's environment"`
+	Up       bool   `help:"Start the environment once restored."`
+	Force    bool   `help:"Replace the data of a persistence path outside of the environment."`
+	Snapshot string `arg:"" help:"Name of the snapshot."`
+}
+
+type SnapshotListCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+}
+
+type SnapshotDeleteCmd struct {
+	EnvDir   string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name     string `help:"Name of the environment. default: 'default'" short:"n"`
+	Snapshot string `arg:"" help:"Name of the snapshot."`
+}
+
+const (
+	snapshotsDir         = ".snapshots"
+	snapshotManifestFile = "manifest.json"
+	snapshotConfigFile   = "config.tar.gz"
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// snapshotManifest describes the content of a snapshot directory.
+type snapshotManifest struct {
+	Name        string            `json:"name"`
+	Environment string            `json:"environment"`
+	Created     time.Time         `json:"created"`
+	Images      []string          `json:"images"`
+	Volumes     []snapshotArchive `json:"volumes"`
+	HostData    []snapshotArchive `json:"host_data"`
+}
+
+// snapshotArchive is a volume or directory archived in the snapshot.
+// Key is the volume name in the compose file, or the directory name below
+// persistence.path.
+type snapshotArchive struct {
+	Key     string `json:"key"`
+	Archive string `json:"archive"`
+}
+
+func snapshotPath(rc RuntimeConfig, name string) string {
+	return filepath.Join(rc.EnvDir, snapshotsDir, rc.EnvName, name)
+}
+
+func (c *SnapshotCreateCmd) Run() error {
+	if !snapshotNamePattern.MatchString(c.Snapshot) {
+		return fmt.Errorf("invalid snapshot name %q", c.Snapshot)
+	}
+
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	snapDir := snapshotPath(rc, c.Snapshot)
+	if _, err := os.Stat(snapDir); err == nil {
+		if !c.Overwrite {
+			return fmt.Errorf("snapshot %s already exists", c.Snapshot)
+		}
+		if err := os.RemoveAll(snapDir); err != nil {
+			return fmt.Errorf("failed to remove snapshot: %w", err)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	running, err := environmentRunning(ctx, orch)
+	if err != nil {
+		return err
+	}
+
+	// Volumes are archived from a stopped stack to get a consistent state
+	if err := orch.Stop(ctx); err != nil {
+		return fmt.Errorf("failed to stop environment: %w", err)
+	}
+
+	manifest, snapErr := createSnapshot(ctx, cfg, rc.EnvName, envPath, c.Snapshot, snapDir)
+	if snapErr != nil {
+		_ = os.RemoveAll(snapDir)
+	}
+
+	if running && !c.NoRestart {
+		if err := orch.Start(ctx); err != nil {
+			return errors.Join(snapErr, fmt.Errorf("failed to restart environment: %w", err))
+		}
+	}
+
+	if snapErr != nil {
+		return fmt.Errorf("failed to create snapshot: %w", snapErr)
+	}
+
+	log.Info().
+		Str("snapshot", manifest.Name).
+		Int("volumes", len(manifest.Volumes)).
+		Int("hostData", len(manifest.HostData)).
+		Msg("Snapshot created")
+	return nil
+}
+
+// createSnapshot archives the configuration, compose volumes and host
+// persistence directories of a stopped environment into snapDir.
+func createSnapshot(ctx context.Context, cfg EnvironmentConfig, envName, envPath, name, snapDir string) (snapshotManifest, error) {
+	manifest := snapshotManifest{
+		Name:        name,
+		Environment: envName,
+		Created:     time.Now().UTC(),
+		Images:      cfg.images(),
+		Volumes:     []snapshotArchive{},
+		HostData:    []snapshotArchive{},
+	}
+
+	if err := os.MkdirAll(snapDir, 0755); err != nil {
+		return manifest, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	if err := archiveEnvConfig(envPath, filepath.Join(snapDir, snapshotConfigFile)); err != nil {
+		return manifest, err
+	}
+
+	project, err := composeProjectName(ctx, cfg, envPath)
+	if err != nil {
+		return manifest, err
+	}
+
+	volumes, err := composeVolumes(ctx, project)
+	if err != nil {
+		return manifest, err
+	}
+
+	for _, key := range sortedKeys(volumes) {
+		archive := filepath.Join("volumes", key+".tar.gz")
+		log.Info().Str("volume", volumes[key]).Msg("Archiving volume")
+		if err := archiveMount(ctx, cfg.helperImage(), volumes[key], filepath.Join(snapDir, archive)); err != nil {
+			return manifest, err
+		}
+		manifest.Volumes = append(manifest.Volumes, snapshotArchive{Key: key, Archive: archive})
+	}
+
+	if cfg.Persistence.Enabled && cfg.Persistence.Mode == persistenceModeHost {
+		for _, store := range persistentStores {
+			dir := filepath.Join(cfg.Persistence.Path, store.Name)
+			if _, err := os.Stat(dir); err != nil {
+				continue
+			}
+			archive := filepath.Join("host", store.Name+".tar.gz")
+			log.Info().Str("path", dir).Msg("Archiving persistent data")
+			if err := archiveMount(ctx, cfg.helperImage(), dir, filepath.Join(snapDir, archive)); err != nil {
+				return manifest, err
+			}
+			manifest.HostData = append(manifest.HostData, snapshotArchive{Key: store.Name, Archive: archive})
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return manifest, fmt.Errorf("failed to encode snapshot manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(snapDir, snapshotManifestFile), append(data, '\n'), 0644); err != nil {
+		return manifest, fmt.Errorf("failed to write snapshot manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// envConfigFiles are the files of an environment directory needed to
+// recreate it, next to the config/ directory.
+var envConfigFiles = []string{"values.yaml", valuesOverridesFile, imageLockFile, kafkaModeFile, "defaults.env", "docker-compose.yaml", composeOverrideFile, ".gitignore"}
+
+func archiveEnvConfig(envPath, output string) (err error) {
+	archive, err := newTarGzWriter(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := archive.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write %s: %w", output, closeErr)
+		}
+	}()
+
+	for _, name := range envConfigFiles {
+		data, readErr := os.ReadFile(filepath.Join(envPath, name))
+		if os.IsNotExist(readErr) {
+			continue
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read %s: %w", name, readErr)
+		}
+		if err := archive.AddFile(name, data); err != nil {
+			return err
+		}
+	}
+
+	configDir := filepath.Join(envPath, "config")
+	if _, statErr := os.Stat(configDir); statErr == nil {
+		if err := archive.AddDir(configDir, "config", nil); err != nil {
+			return fmt.Errorf("failed to archive config: %w", err)
+		}
+	}
+	return nil
+}
+
+// archiveMount streams the content of a volume or host directory as a
+// tarball from a helper container, as its files may belong to other users.
+func archiveMount(ctx context.Context, image, source, output string) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", output, err)
+	}
+	defer func() { _ = out.Close() }()
+
+	cmd := exec.CommandContext(ctx, currentContainerEngine().CLI, "run", "--rm", "--volume", source+":/source:ro",
+		image, "tar", "czf", "-", "-C", "/source", ".")
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", source, err)
+	}
+	return out.Close()
+}
+
+// restoreMount replaces the content of a volume or host directory with a
+// tarball created by archiveMount.
+func restoreMount(ctx context.Context, image, target, input string) error {
+	in, err := os.Open(input)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", input, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	cmd := exec.CommandContext(ctx, currentContainerEngine().CLI, "run", "--rm", "--interactive", "--volume", target+":/target",
+		image, "sh", "-c", "rm -rf /target/* /target/.[!.]* && tar xzf - -C /target")
+	cmd.Stdin = in
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", target, err)
+	}
+	return nil
+}
+
+// composeProjectName returns the compose project name of an environment.
+func composeProjectName(ctx context.Context, cfg EnvironmentConfig, envPath string) (string, error) {
+	out, err := commandOutput(ctx, envPath, buildDockerComposeCommand(cfg, "config", "--format", "json")...)
+	if err != nil {
+		return "", fmt.Errorf("failed to read compose config: %w", err)
+	}
+
+	var config struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(out, &config); err != nil || config.Name == "" {
+		return "", fmt.Errorf("failed to parse compose config: %w", err)
+	}
+	return config.Name, nil
+}
+
+// composeVolumes returns the docker volumes of a compose project by their
+// key in the compose file.
+func composeVolumes(ctx context.Context, project string) (map[string]string, error) {
+	out, err := commandOutput(ctx, "", currentContainerEngine().CLI, "volume", "ls",
+		"--filter", "label=com.docker.compose.project="+project,
+		"--format", `{{.Name}}	{{.Label "com.docker.compose.volume"}}`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	volumes := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		name, key, ok := strings.Cut(line, "\t")
+		if !ok || key == "" {
+			continue
+		}
+		volumes[key] = name
+	}
+	return volumes, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func readSnapshotManifest(snapDir string) (snapshotManifest, error) {
+	var manifest snapshotManifest
+	data, err := os.ReadFile(filepath.Join(snapDir, snapshotManifestFile))
+	if err != nil {
+		return manifest, fmt.Errorf("failed to read snapshot manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse snapshot manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+func (c *SnapshotRestoreCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	snapDir := snapshotPath(rc, c.Snapshot)
+
+	target := rc.EnvName
+	if c.To != "" {
+		target = c.To
+	}
+	envPath := filepath.Join(rc.EnvDir, target)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	cfg, err := restoreSnapshot(ctx, snapDir, envPath, c.Force)
+	if err != nil {
+		return fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	log.Info().Str("snapshot", c.Snapshot).Str("environment", target).Msg("Snapshot restored")
+
+	if c.Up {
+		if err := writeConnectionManifest(cfg, target, envPath); err != nil {
+			return fmt.Errorf("failed to write connection manifest: %w", err)
+		}
+		orch, err := newOrchestrator(cfg, envPath)
+		if err != nil {
+			return err
+		}
+		return orch.Up(ctx, UpOptions{Detach: true})
+	}
+	return nil
+}
+
+// restoreSnapshot replaces the configuration and data of the environment at
+// envPath with the content of a snapshot. The environment is created if needed.
+// The data of a persistence path outside of the environment is only replaced
+// with force.
+func restoreSnapshot(ctx context.Context, snapDir, envPath string, force bool) (EnvironmentConfig, error) {
+	manifest, err := readSnapshotManifest(snapDir)
+	if err != nil {
+		return EnvironmentConfig{}, err
+	}
+
+	if len(manifest.HostData) > 0 && !force {
+		persistence, err := snapshotPersistence(snapDir, envPath)
+		if err != nil {
+			return EnvironmentConfig{}, err
+		}
+		if persistence.Enabled && persistence.Mode == persistenceModeHost && !isBelow(persistence.Path, envPath) {
+			return EnvironmentConfig{}, fmt.Errorf("persistence path %s is outside of the environment, pass --force to replace its data", persistence.Path)
+		}
+	}
+
+	// Remove the containers of the target, they hold on to its volumes
+	cfgPath := filepath.Join(envPath, "values.yaml")
+	if _, err := os.Stat(cfgPath); err == nil {
+		current, err := LoadEnvironmentConfig(cfgPath)
+		if err != nil {
+			return current, err
+		}
+		orch, err := newOrchestrator(current, envPath)
+		if err != nil {
+			return current, err
+		}
+		if err := orch.Down(ctx, DownOptions{Timeout: 10}); err != nil {
+			return current, fmt.Errorf("failed to stop environment: %w", err)
+		}
+	}
+
+	if err := os.MkdirAll(envPath, 0755); err != nil {
+		return EnvironmentConfig{}, fmt.Errorf("failed to create environment directory: %w", err)
+	}
+
+	if err := os.RemoveAll(filepath.Join(envPath, "config")); err != nil {
+		return EnvironmentConfig{}, fmt.Errorf("failed to remove config: %w", err)
+	}
+	// Only the snapshot's overrides and kafka mode apply, if it has any
+	for _, name := range []string{valuesOverridesFile, kafkaModeFile} {
+		if err := os.Remove(filepath.Join(envPath, name)); err != nil && !os.IsNotExist(err) {
+			return EnvironmentConfig{}, fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	if err := extractTarGz(filepath.Join(snapDir, snapshotConfigFile), envPath); err != nil {
+		return EnvironmentConfig{}, err
+	}
+
+	cfg, err := LoadEnvironmentConfig(cfgPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := createLogDirectories(envPath); err != nil {
+		return cfg, fmt.Errorf("failed to create log directories: %w", err)
+	}
+
+	project, err := composeProjectName(ctx, cfg, envPath)
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, volume := range manifest.Volumes {
+		name := project + "_" + volume.Key
+		log.Info().Str("volume", name).Msg("Restoring volume")
+
+		// Recreate the volume with the labels compose expects
+		if _, err := commandOutput(ctx, "", currentContainerEngine().CLI, "volume", "rm", "--force", name); err != nil {
+			return cfg, err
+		}
+		if _, err := commandOutput(ctx, "", currentContainerEngine().CLI, "volume", "create",
+			"--label", "com.docker.compose.project="+project,
+			"--label", "com.docker.compose.volume="+volume.Key,
+			name); err != nil {
+			return cfg, err
+		}
+		if err := restoreMount(ctx, cfg.helperImage(), name, filepath.Join(snapDir, volume.Archive)); err != nil {
+			return cfg, err
+		}
+	}
+
+	if len(manifest.HostData) > 0 {
+		if !cfg.Persistence.Enabled || cfg.Persistence.Mode != persistenceModeHost {
+			return cfg, errors.New("snapshot contains host persistence data but the environment does not use host persistence")
+		}
+		if !isBelow(cfg.Persistence.Path, envPath) {
+			log.Warn().Str("path", cfg.Persistence.Path).Msg("Persistence path is outside of the environment, replacing its data")
+		}
+		if err := createPersistentDirectories(cfg); err != nil {
+			return cfg, err
+		}
+		for _, data := range manifest.HostData {
+			dir := filepath.Join(cfg.Persistence.Path, data.Key)
+			log.Info().Str("path", dir).Msg("Restoring persistent data")
+			if err := restoreMount(ctx, cfg.helperImage(), dir, filepath.Join(snapDir, data.Archive)); err != nil {
+				return cfg, err
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// snapshotPersistence returns the persistence config of a snapshot, resolved
+// against the environment it is restored into.
+func snapshotPersistence(snapDir, envPath string) (PersistenceConfig, error) {
+	tmpDir, err := os.MkdirTemp("", "workbench-snapshot-")
+	if err != nil {
+		return PersistenceConfig{}, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	if err := extractTarGz(filepath.Join(snapDir, snapshotConfigFile), tmpDir); err != nil {
+		return PersistenceConfig{}, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, "values.yaml"))
+	if err != nil {
+		return PersistenceConfig{}, fmt.Errorf("failed to read snapshot values: %w", err)
+	}
+	overridesPath := filepath.Join(tmpDir, valuesOverridesFile)
+	if _, err := os.Stat(overridesPath); err == nil {
+		data, err = mergeValuesOverrides(data, overridesPath)
+		if err != nil {
+			return PersistenceConfig{}, err
+		}
+	}
+
+	var values struct {
+		Persistence PersistenceConfig `yaml:"persistence"`
+	}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return PersistenceConfig{}, fmt.Errorf("failed to parse snapshot values: %w", err)
+	}
+	if err := values.Persistence.resolve(envPath); err != nil {
+		return PersistenceConfig{}, err
+	}
+	return values.Persistence, nil
+}
+
+func (c *SnapshotListCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	root := filepath.Join(rc.EnvDir, snapshotsDir, rc.EnvName)
+
+	entries, err := os.ReadDir(root)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tCREATED\tSIZE\tVOLUMES")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		snapDir := filepath.Join(root, entry.Name())
+		manifest, err := readSnapshotManifest(snapDir)
+		if err != nil {
+			log.Warn().Err(err).Str("snapshot", entry.Name()).Msg("Skipping invalid snapshot")
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%d\n",
+			manifest.Name,
+			manifest.Created.Local().Format(time.DateTime),
+			formatSize(dirSize(snapDir)),
+			len(manifest.Volumes)+len(manifest.HostData))
+	}
+	return w.Flush()
+}
+
+func (c *SnapshotDeleteCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	snapDir := snapshotPath(rc, c.Snapshot)
+	if _, err := os.Stat(filepath.Join(snapDir, snapshotManifestFile)); err != nil {
+		return fmt.Errorf("snapshot %s does not exist", c.Snapshot)
+	}
+
+	if err := os.RemoveAll(snapDir); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+
+	log.Info().Str("snapshot", c.Snapshot).Msg("Snapshot deleted")
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+)
+
+type StatusCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment. default: 'default'" short:"n"`
+}
+
+func (c *StatusCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	containers, err := orch.Ps(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	if err := writeContainers(os.Stdout, containers); err != nil {
+		return err
+	}
+
+	endpoints := debugEndpoints(cfg)
+	if len(endpoints) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SERVICE\tPROCESS\tDEBUG PORT\tPROFILES")
+	for _, e := range endpoints {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", e.Service, e.Process, e.Port, e.Profiles)
+	}
+	return w.Flush()
+}
+
+// writeContainers writes a table of the state of containers.
+func writeContainers(out io.Writer, containers []ContainerState) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SERVICE\tCONTAINER\tSTATE\tHEALTH")
+	for _, c := range containers {
+		state := c.State
+		if c.State == "exited" {
+			state = fmt.Sprintf("exited (%d)", c.ExitCode)
+		}
+		health := c.Health
+		if health == "" {
+			health = "-"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Service, c.Name, state, health)
+	}
+	return w.Flush()
+}
+
+// scubaPrograms lists the supervisord programs of scuba in port offset order.
+var scubaPrograms = []string{"ingest-daemon-1", "ingest-daemon-2", "ingest-daemon-3", "query-server"}
+
+type debugEndpoint struct {
+	Service  string
+	Process  string
+	Port     int
+	Profiles string
+}
+
+// debugEndpoints lists the inspector ports of the enabled node processes.
+func debugEndpoints(cfg EnvironmentConfig) []debugEndpoint {
+	var endpoints []debugEndpoint
+	add := func(service, process string, debug DebugConfig, offset int) {
+		profiles := "-"
+		if debug.CPUProf || debug.HeapProf {
+			profiles = filepath.Join("logs", service, "profiles")
+		}
+		endpoints = append(endpoints, debugEndpoint{
+			Service:  service,
+			Process:  process,
+			Port:     int(debug.Port) + offset,
+			Profiles: profiles,
+		})
+	}
+
+	if cfg.Cloudserver.Debug.Enabled {
+		add("cloudserver", "cloudserver", cfg.Cloudserver.Debug, 0)
+	}
+
+	if cfg.Vault.Debug.Enabled {
+		add("vault", "vault", cfg.Vault.Debug, 0)
+	}
+
+	if cfg.Utapi.Debug.Enabled && cfg.Features.Utapi.Enabled {
+		add("utapi", "utapi", cfg.Utapi.Debug, 0)
+	}
+
+	if cfg.Scuba.Debug.Enabled && cfg.Features.Scuba.Enabled {
+		for i, name := range scubaPrograms {
+			add("scuba", name, cfg.Scuba.Debug, i)
+		}
+	}
+
+	if cfg.Backbeat.Debug.Enabled {
+		for _, p := range cfg.Backbeat.Programs {
+			add("backbeat", p.Name, cfg.Backbeat.Debug, p.Offset)
+		}
+	}
+
+	return endpoints
+}
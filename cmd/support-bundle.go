@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+type SupportBundleCmd struct {
+	EnvDir string `help:"Directory containing the environment. default: './env'" short:"d"`
+	Name   string `help:"Name of the environment to collect diagnostics for. default: 'default'" short:"n"`
+	Output string `help:"Path of the generated tarball. default: '<env-dir>/<name>-support-bundle-<timestamp>.tar.gz'" short:"o"`
+}
+
+func (c *SupportBundleCmd) Run() error {
+	rc := RuntimeConfigFromFlags(c.EnvDir, c.Name)
+	envPath := filepath.Join(rc.EnvDir, rc.EnvName)
+	if _, err := os.Stat(envPath); err != nil {
+		return fmt.Errorf("failed to stat environment: %w", err)
+	}
+
+	cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+	if err != nil {
+		return err
+	}
+
+	output := c.Output
+	if output == "" {
+		output = defaultSupportBundlePath(rc)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
+
+	return createSupportBundle(ctx, cfg, orch, envPath, output)
+}
+
+func defaultSupportBundlePath(rc RuntimeConfig) string {
+	name := fmt.Sprintf("%s-support-bundle-%s.tar.gz", rc.EnvName, time.Now().Format("20060102-150405"))
+	return filepath.Join(rc.EnvDir, name)
+}
+
+// createSupportBundle collects everything needed to debug an environment into
+// a single tarball. Collection is best effort: a failing command is recorded in
+// the bundle instead of aborting.
+func createSupportBundle(ctx context.Context, cfg EnvironmentConfig, orch Orchestrator, envPath, output string) (err error) {
+	log.Info().Str("output", output).Msg("Collecting support bundle")
+
+	bundle, err := newTarGzWriter(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := bundle.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to write support bundle: %w", closeErr)
+		}
+	}()
+
+	// Rendered configuration, with credentials redacted
+	configDir := filepath.Join(envPath, "config")
+	if _, statErr := os.Stat(configDir); statErr == nil {
+		if err := bundle.AddDir(configDir, "config", redactFile); err != nil {
+			return fmt.Errorf("failed to archive config: %w", err)
+		}
+	}
+
+	for _, name := range []string{"values.yaml", valuesOverridesFile, "defaults.env", "docker-compose.yaml"} {
+		data, readErr := os.ReadFile(filepath.Join(envPath, name))
+		if readErr != nil {
+			continue
+		}
+		if err := bundle.AddFile(name, redactFile(name, data)); err != nil {
+			return err
+		}
+	}
+
+	effective, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode effective values: %w", err)
+	}
+	if err := bundle.AddFile("effective-values.yaml", redactFile("effective-values.yaml", effective)); err != nil {
+		return err
+	}
+
+	// File based logs written through the logs/ mounts
+	logsDir := filepath.Join(envPath, "logs")
+	if _, statErr := os.Stat(logsDir); statErr == nil {
+		if err := bundle.AddDir(logsDir, "logs", nil); err != nil {
+			return fmt.Errorf("failed to archive logs: %w", err)
+		}
+	}
+
+	// Container state
+	containers, err := orch.Ps(ctx)
+	ps := bytes.NewBuffer(nil)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to list containers")
+		fmt.Fprintf(ps, "# failed to list containers: %v\n", err)
+	} else if err := writeContainers(ps, containers); err != nil {
+		return err
+	}
+	if err := bundle.AddFile("docker/ps.txt", ps.Bytes()); err != nil {
+		return err
+	}
+
+	var names, services []string
+	for _, c := range containers {
+		names = append(names, c.Name)
+		if !slices.Contains(services, c.Service) {
+			services = append(services, c.Service)
+		}
+	}
+	if len(names) > 0 {
+		inspect, err := orch.Inspect(ctx, names)
+		if err != nil {
+			inspect = append(inspect, []byte(fmt.Sprintf("\n# %v\n", err))...)
+		}
+		if err := bundle.AddFile("docker/inspect.json", redactFile("inspect.json", inspect)); err != nil {
+			return err
+		}
+	}
+
+	slices.Sort(services)
+	for _, service := range services {
+		logs := bytes.NewBuffer(nil)
+		err := orch.Logs(ctx, LogsOptions{Services: []string{service}, Timestamps: true}, func(_, line string) {
+			logs.WriteString(line + "\n")
+		})
+		if err != nil {
+			fmt.Fprintf(logs, "\n# %v\n", err)
+		}
+		if err := bundle.AddFile(filepath.Join("compose-logs", service+".log"), logs.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err := bundle.AddFile("docker/images.txt", collectImageDigests(ctx, cfg)); err != nil {
+		return err
+	}
+
+	if err := bundle.AddFile("host.txt", collectHostInfo(ctx)); err != nil {
+		return err
+	}
+
+	log.Info().Str("output", output).Msg("Support bundle created")
+	return nil
+}
+
+// commandOutput runs a command and returns its standard output.
+func commandOutput(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return out, fmt.Errorf("%s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// captureCommand runs a command and returns its combined output.
+// Failures are reported inline so they end up in the collected file.
+func captureCommand(ctx context.Context, dir string, args ...string) []byte {
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		out = append(out, []byte(fmt.Sprintf("\n# %s: %v\n", strings.Join(args, " "), err))...)
+	}
+	return out
+}
+
+func collectImageDigests(ctx context.Context, cfg EnvironmentConfig) []byte {
+	buf := bytes.NewBuffer(nil)
+	for _, image := range cfg.images() {
+		out := captureCommand(ctx, "", currentContainerEngine().CLI, "image", "inspect", "--format", "{{.Id}} {{json .RepoDigests}}", image)
+		fmt.Fprintf(buf, "%s %s", image, out)
+	}
+	return buf.Bytes()
+}
+
+func collectHostInfo(ctx context.Context) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "## runtime\n%s/%s %s\n\n", runtime.GOOS, runtime.GOARCH, runtime.Version())
+
+	engine := currentContainerEngine()
+	commands := [][]string{
+		{"uname", "-a"},
+		engine.command("version"),
+		append(slices.Clone(engine.Compose), "version"),
+		engine.command("info"),
+		{"df", "-h"},
+	}
+	for _, args := range commands {
+		fmt.Fprintf(buf, "## %s\n%s\n", strings.Join(args, " "), captureCommand(ctx, "", args...))
+	}
+	return buf.Bytes()
+}
+
+// credentialFiles hold nothing but credentials, every string value is redacted.
+var credentialFiles = []string{
+	"management-creds.json",
+	"admin-backbeat.json",
+	"notificationCredentials.json",
+	"scuba-service-creds.json",
+	"rate-limit-service-creds.json",
+}
+
+// secretKeyPattern matches the JSON keys holding credentials, everything
+// below them is redacted.
+var secretKeyPattern = regexp.MustCompile(`(?i)secret|password|passwd|token|credential|access_?key|^sk$|^user_`)
+
+var secretValuePattern = regexp.MustCompile(
+	`(?i)(["']?\b(?:[\w.-]*(?:secret|password|passwd|token)[\w.-]*|sk|user_[\w.-]+)["']?\s*[:=]\s*)("[^"]*"|'[^']*'|[^\s,;}]+)`,
+)
+
+const redacted = "REDACTED"
+
+// redactFile removes credentials from a file before it is added to a bundle.
+func redactFile(path string, data []byte) []byte {
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+
+	if ext == ".key" || ext == ".pem" {
+		return []byte(redacted + "\n")
+	}
+
+	if slices.Contains(credentialFiles, base) {
+		return redactJSONValues(data)
+	}
+	if ext == ".json" {
+		if out, ok := redactJSONSecrets(data); ok {
+			return out
+		}
+	}
+
+	return secretValuePattern.ReplaceAllFunc(data, func(match []byte) []byte {
+		groups := secretValuePattern.FindSubmatch(match)
+		out := append([]byte{}, groups[1]...)
+		value := groups[2]
+		// Shell variables and jq paths are not secrets
+		if bytes.HasPrefix(value, []byte("$")) || bytes.HasPrefix(value, []byte(".")) {
+			return match
+		}
+		if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+			return append(out, []byte(string(value[0])+redacted+string(value[0]))...)
+		}
+		return append(out, []byte(redacted)...)
+	})
+}
+
+// redactJSONValues redacts every string value of a JSON document, at any
+// depth.
+func redactJSONValues(data []byte) []byte {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []byte(redacted + "\n")
+	}
+
+	out, err := json.MarshalIndent(redactJSON(doc, true), "", "    ")
+	if err != nil {
+		return []byte(redacted + "\n")
+	}
+	return out
+}
+
+// redactJSONSecrets redacts the values below the secret keys of a JSON
+// document, at any depth. It fails on documents that don't parse, e.g.
+// templates, left to secretValuePattern.
+func redactJSONSecrets(data []byte) ([]byte, bool) {
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false
+	}
+
+	out, err := json.MarshalIndent(redactJSON(doc, false), "", "    ")
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+// redactJSON walks the maps and arrays of a JSON value, redacting its
+// strings when all is set or below a key matching secretKeyPattern.
+func redactJSON(value any, all bool) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			v[key] = redactJSON(child, all || secretKeyPattern.MatchString(key))
+		}
+	case []any:
+		for i, child := range v {
+			v[i] = redactJSON(child, all)
+		}
+	case string:
+		if all {
+			return redacted
+		}
+	}
+	return value
+}
@@ -4,8 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"strings"
@@ -15,15 +13,21 @@ import (
 )
 
 type UpCmd struct {
-	EnvDir            string `help:"Directory containing the environment. default:'./env'"`
-	Name              string `help:"Name of the environment to start. default: 'default'" short:"n"`
-	NoConfigure       bool   `help:"Don't template config files before starting containers"`
-	Overwrite         bool   `help:"Overwrite existing environment if it exists." short:"o"`
-	Detach            bool   `help:"Run containers in detached mode." short:"d"`
-	Build             bool   `help:"Build images before starting containers." short:"b"`
-	NoCache           bool   `help:"Do not use cache when building images." short:"c"`
-	WithConfig        string `help:"Path to a custom configuration file. Replaces the default config." type:"existingfile"`
-	WithDockerCompose string `help:"Path to a custom Docker Compose file. Replaces the default file." type:"existingfile"`
+	EnvDir            string   `help:"Directory containing the environment. default:'./env'"`
+	Name              string   `help:"Name of the environment to start. default: 'default'" short:"n"`
+	NoConfigure       bool     `help:"Don't template config files before starting containers"`
+	Overwrite         bool     `help:"Overwrite existing environment if it exists." short:"o"`
+	Detach            bool     `help:"Run containers in detached mode." short:"d"`
+	Build             bool     `help:"Build images before starting containers." short:"b"`
+	NoCache           bool     `help:"Do not use cache when building images." short:"c"`
+	WithConfig        string   `help:"Path to a custom configuration file. Replaces the default config." type:"existingfile"`
+	WithDockerCompose string   `help:"Path to a custom Docker Compose file. Replaces the default file." type:"existingfile"`
+	Values            []string `help:"Path to a values file merged on top of the environment's values.yaml until the next run given --values or --feature. Can be repeated." type:"existingfile"`
+	Feature           []string `help:"Enable a feature on top of the environment's values.yaml until the next run given --values or --feature (e.g. scuba,lifecycle). Can be repeated."`
+	ResetOverrides    bool     `help:"Drop the values and features of earlier --values and --feature flags."`
+	BundleOnFailure   bool     `help:"Collect a support bundle if the environment fails to start."`
+	Offline           bool     `help:"Never pull images, fail early if an image is missing locally."`
+	NoDoctor          bool     `help:"Skip the preflight checks of workbench doctor."`
 }
 
 func (c *UpCmd) Run() error {
@@ -35,6 +39,10 @@ func (c *UpCmd) Run() error {
 	}
 
 	cfgPath := filepath.Join(envPath, "values.yaml")
+	if err := writeValuesOverrides(envPath, c.Values, c.Feature, c.ResetOverrides || c.Overwrite); err != nil {
+		return fmt.Errorf("failed to apply values overrides: %w", err)
+	}
+
 	cfg, err := LoadEnvironmentConfig(cfgPath)
 	if err != nil {
 		return err
@@ -46,34 +54,53 @@ func (c *UpCmd) Run() error {
 		}
 	}
 
-	args := []string{"up"}
-	if c.Detach {
-		args = append(args, "--detach")
+	if err := writeConnectionManifest(cfg, rc.EnvName, envPath); err != nil {
+		return fmt.Errorf("failed to write connection manifest: %w", err)
 	}
 
-	if c.Build {
-		args = append(args, "--build")
+	opts := UpOptions{
+		Detach:  c.Detach,
+		Build:   c.Build,
+		NoCache: c.NoCache,
 	}
 
-	if c.NoCache {
-		args = append(args, "--no-cache")
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if !c.NoDoctor {
+		if err := preflight(ctx, cfg, envPath); err != nil {
+			return err
+		}
 	}
 
-	dockerComposeCmd := buildDockerComposeCommand(cfg, args...)
+	if c.Offline {
+		// compose would otherwise wait for pulls to time out
+		images, err := composeImages(ctx, cfg, envPath)
+		if err != nil {
+			return err
+		}
+		if missing := missingImages(ctx, images); len(missing) > 0 {
+			return fmt.Errorf("images missing for offline mode, load them with workbench images load: %s", strings.Join(missing, ", "))
+		}
+		opts.Pull = "never"
+	}
 
-	log.Info().Str("command", strings.Join(dockerComposeCmd, " ")).Msg("Starting environment")
+	orch, err := newOrchestrator(cfg, envPath)
+	if err != nil {
+		return err
+	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
+	log.Info().Str("environment", rc.EnvName).Msg("Starting environment")
 
-	cmd := exec.CommandContext(ctx, dockerComposeCmd[0], dockerComposeCmd[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = envPath
-	if err := cmd.Run(); err != nil {
+	if err := orch.Up(ctx, opts); err != nil {
 		if errors.Is(ctx.Err(), context.Canceled) {
 			return nil
 		}
+		if c.BundleOnFailure {
+			if bundleErr := createSupportBundle(context.Background(), cfg, orch, envPath, defaultSupportBundlePath(rc)); bundleErr != nil {
+				log.Error().Err(bundleErr).Msg("Failed to collect support bundle")
+			}
+		}
 		return err
 	}
 
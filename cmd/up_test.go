@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestUpCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, envPath := newTestEnv(t)
+
+	cmd := UpCmd{EnvDir: envDir, Name: DefaultEnvName, Detach: true, NoDoctor: true}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+
+	assertCalls(t, orch, "up detach=true build=false pull=")
+	for _, file := range []string{"defaults.env", composeOverrideFile, "config/cloudserver/config.json"} {
+		if _, err := os.Stat(filepath.Join(envPath, file)); err != nil {
+			t.Errorf("up did not configure the environment: %v", err)
+		}
+	}
+
+	running, err := environmentRunning(t.Context(), orch)
+	if err != nil || !running {
+		t.Errorf("expected running containers after up, got %t, %v", running, err)
+	}
+}
+
+func TestDownCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, _ := newTestEnv(t)
+
+	up := UpCmd{EnvDir: envDir, Name: DefaultEnvName, Detach: true, NoDoctor: true}
+	if err := up.Run(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	down := DownCmd{EnvDir: envDir, Name: DefaultEnvName, Timeout: 5, Volumes: true}
+	if err := down.Run(); err != nil {
+		t.Fatalf("down failed: %v", err)
+	}
+
+	assertCalls(t, orch, "up detach=true build=false pull=", "down timeout=5 volumes=true")
+	if running, _ := environmentRunning(t.Context(), orch); running {
+		t.Error("expected no running containers after down")
+	}
+}
+
+func TestDownCmdMissingEnv(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+
+	down := DownCmd{EnvDir: t.TempDir(), Name: "missing", Timeout: 10}
+	if err := down.Run(); err == nil {
+		t.Fatal("expected down to fail on a missing environment")
+	}
+	assertCalls(t, orch)
+}
+
+func TestDestroyCmd(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, envPath := newTestEnv(t)
+
+	destroy := DestroyCmd{EnvDir: envDir, Name: DefaultEnvName, Timeout: 10}
+	if err := destroy.Run(); err != nil {
+		t.Fatalf("destroy failed: %v", err)
+	}
+
+	assertCalls(t, orch, "down timeout=10 volumes=true")
+	if _, err := os.Stat(envPath); !os.IsNotExist(err) {
+		t.Errorf("expected the environment to be removed, got %v", err)
+	}
+}
+
+func TestUpCmdFeatures(t *testing.T) {
+	orch := useFakeOrchestrator(t)
+	envDir, envPath := newTestEnv(t)
+	lifecycleEnabled := func() bool {
+		t.Helper()
+		cfg, err := LoadEnvironmentConfig(filepath.Join(envPath, "values.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg.Features.Lifecycle.Enabled
+	}
+
+	cmd := UpCmd{EnvDir: envDir, Name: DefaultEnvName, Detach: true, NoDoctor: true, Feature: []string{"lifecycle"}}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	containers, err := orch.Ps(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.ContainsFunc(containers, func(c ContainerState) bool { return c.Service == "backbeat" }) {
+		t.Errorf("expected backbeat to run with lifecycle, got %v", containers)
+	}
+
+	// An up without flags keeps the features of the previous one
+	cmd.Feature = nil
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if !lifecycleEnabled() {
+		t.Error("expected lifecycle to stay enabled")
+	}
+
+	cmd.ResetOverrides = true
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("up failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(envPath, valuesOverridesFile)); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", valuesOverridesFile, err)
+	}
+	if lifecycleEnabled() {
+		t.Error("expected lifecycle to be disabled after a reset")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"text/template"
@@ -77,6 +78,10 @@ func getComposeProfiles(cfg EnvironmentConfig) []string {
 		profiles = append(profiles, "feature-notifications")
 	}
 
+	if cfg.receiverEnabled() {
+		profiles = append(profiles, "feature-notifications-receiver")
+	}
+
 	if cfg.Features.Utapi.Enabled {
 		profiles = append(profiles, "feature-utapi")
 	}
@@ -111,12 +116,10 @@ func getComposeProfiles(cfg EnvironmentConfig) []string {
 func buildDockerComposeCommand(cfg EnvironmentConfig, args ...string) []string {
 	profiles := getComposeProfiles(cfg)
 
-	dockerComposeCmd := []string{
-		"docker",
-		"compose",
+	dockerComposeCmd := append(slices.Clone(currentContainerEngine().Compose),
 		"--env-file",
 		"defaults.env",
-	}
+	)
 
 	for _, profile := range profiles {
 		dockerComposeCmd = append(dockerComposeCmd, "--profile", profile)
@@ -159,6 +162,8 @@ func copyFile(src, dest string) (err error) {
 // Only recognizes semver-style tags (digits followed by a dot). Git SHAs and other
 // non-semver tags default to "v9".
 func detectCloudserverVersion(image string) string {
+	// Ignore the registry, its port would be taken for the tag
+	image = image[strings.LastIndex(image, "/")+1:]
 	parts := strings.Split(image, ":")
 	if len(parts) < 2 || parts[1] == "" {
 		return "v9"
@@ -0,0 +1,16 @@
+#!/bin/bash
+# Restarts the backbeat programs when a source file of the mounted checkout changes.
+
+SOURCE_DIR="{{ .Backbeat.Dev.Workdir }}"
+STAMP=/tmp/dev-reload.stamp
+SUPERVISORCTL="supervisorctl -c /conf/supervisord.conf"
+
+touch "$STAMP"
+while sleep 2; do
+    changed=$(find "$SOURCE_DIR" -path '*/node_modules' -prune -o \( -name '*.js' -o -name '*.json' \) -newer "$STAMP" -print -quit)
+    if [ -n "$changed" ]; then
+        touch "$STAMP"
+        echo "$changed changed, restarting programs"
+        $SUPERVISORCTL status | awk '{print $1}' | grep -v '^dev-reload' | xargs -r $SUPERVISORCTL restart
+    fi
+done
@@ -22,23 +22,77 @@ echo "[setup] Kafka is ready!"
 echo
 
 
+if [[ -n "$KAFKA_BROKERS" ]] && (( KAFKA_BROKERS > 1 )); then
+    # Topics can't be replicated on more brokers than are registered
+    echo "[setup] Waiting for $KAFKA_BROKERS brokers..."
+    until (( $(kafka-broker-api-versions.sh --bootstrap-server "$KAFKA_BROKER" 2>/dev/null | grep -c '(id: ' || true) >= KAFKA_BROKERS )); do
+        echo "[setup] Brokers not ready, retrying in 2s..."
+        sleep 2
+    done
+    echo "[setup] Brokers are ready!"
+    echo
+fi
+
+# Topics are name[:partitions[:replication_factor[:retention_ms]]]
 if [[ -n "$TOPICS_TO_CREATE" ]]; then
-    echo "[setup] Topics to create: $topic"
+    echo "[setup] Topics to create: $TOPICS_TO_CREATE"
     echo
-    common_opts="--create --if-not-exists --bootstrap-server $KAFKA_BROKER --partitions 1 --replication-factor 1"
+    common_opts="--bootstrap-server $KAFKA_BROKER"
     if [[ -n "$JAAS_CONFIG" ]]; then
         common_opts="$common_opts --command-config $JAAS_CONFIG"
     fi
-    for topic in $TOPICS_TO_CREATE; do
+    for spec in $TOPICS_TO_CREATE; do
+        IFS=: read -r topic partitions replication_factor retention_ms <<< "$spec"
+        partitions=${partitions:-1}
+        replication_factor=${replication_factor:-1}
+
         echo "[setup] Creating topic: $topic"
+        topic_opts="--partitions $partitions --replication-factor $replication_factor"
+        if [[ -n "$retention_ms" ]]; then
+            topic_opts="$topic_opts --config retention.ms=$retention_ms"
+        fi
         kafka-topics.sh \
             $common_opts \
+            --create \
+            --if-not-exists \
             --topic "$topic" \
+            $topic_opts
+
+        # Topics of a previous run keep their settings, partitions can only
+        # be added
+        current=$(kafka-topics.sh $common_opts --describe --topic "$topic" | grep -c 'Partition: ' || true)
+        if (( current < partitions )); then
+            echo "[setup] Increasing the partitions of '$topic' from $current to $partitions"
+            kafka-topics.sh $common_opts --alter --topic "$topic" --partitions "$partitions"
+        fi
+        if [[ -n "$retention_ms" ]]; then
+            kafka-configs.sh \
+                $common_opts \
+                --alter \
+                --entity-type topics \
+                --entity-name "$topic" \
+                --add-config "retention.ms=$retention_ms"
+        fi
         echo "[setup] Topic '$topic' created (or already exists)."
         echo
     done
 fi
 
+if [[ -n "$SCRAM_USERS" ]]; then
+    echo "[setup] Creating SCRAM users"
+    for entry in $SCRAM_USERS; do
+        IFS=: read -r mechanism user password <<< "$entry"
+        kafka-configs.sh \
+            --bootstrap-server "$KAFKA_BROKER" \
+            --alter \
+            --add-config "${mechanism}=[password=${password}]" \
+            --entity-type users \
+            --entity-name "$user"
+        echo "[setup] User '$user' created for $mechanism."
+    done
+    echo
+fi
+
 if [[ "$CREATE_ZOOKEEPER_PATHS" == "true" ]]; then
     if [[ -z "$ZOOKEEPER_ENDPOINT" ]]; then
         echo "[setup] Zookeeper endpoint not set"
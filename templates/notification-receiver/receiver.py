@@ -0,0 +1,52 @@
+"""Records the requests of webhook notification destinations.
+
+Each request is appended to /logs/events.jsonl, where workbench notifications
+received reads them.
+"""
+
+import json
+import os
+import sys
+import threading
+from datetime import datetime, timezone
+from http.server import BaseHTTPRequestHandler, ThreadingHTTPServer
+
+PORT = int(os.environ.get("RECEIVER_PORT", "{{ .NotificationReceiver.Port }}"))
+EVENTS = "/logs/events.jsonl"
+
+lock = threading.Lock()
+
+
+class Handler(BaseHTTPRequestHandler):
+    def do_GET(self):
+        self.send_response(200 if self.path == "/_health" else 404)
+        self.end_headers()
+
+    def do_POST(self):
+        length = int(self.headers.get("Content-Length", 0))
+        body = self.rfile.read(length)
+        try:
+            payload = json.loads(body)
+        except ValueError:
+            payload = body.decode("utf-8", "replace")
+
+        record = {
+            "receivedAt": datetime.now(timezone.utc).isoformat(),
+            "destination": self.path.split("?")[0].strip("/"),
+            "body": payload,
+        }
+        with lock, open(EVENTS, "a") as f:
+            f.write(json.dumps(record) + "\n")
+
+        self.send_response(200)
+        self.end_headers()
+
+    do_PUT = do_POST
+
+    def log_message(self, format, *args):
+        sys.stdout.write("%s %s\n" % (self.address_string(), format % args))
+
+
+if __name__ == "__main__":
+    print("Listening on port %d" % PORT)
+    ThreadingHTTPServer(("0.0.0.0", PORT), Handler).serve_forever()